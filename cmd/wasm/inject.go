@@ -2,34 +2,209 @@ package main
 
 import (
 	"bytes"
+	"io"
+	"mime"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 
 	_ "embed"
 )
 
+// PolyfillJS is the polyfill script injected into HTML pages. When injecting
+// it by src rather than inline, serve it at InjectConfig.SrcPath.
+//
 //go:embed polyfill.js
-var polyfillJS []byte
+var PolyfillJS []byte
+
+// DefaultPolyfillPath is where an external polyfill script is loaded from when
+// InjectConfig.SrcPath is empty
+const DefaultPolyfillPath = "/__portal/polyfill.js"
+
+// InjectPosition is where in the document the polyfill script goes
+type InjectPosition int
+
+const (
+	// HeadFirst makes the script the first child of <head>, or of <body>
+	// when there is no head. This is the default.
+	HeadFirst InjectPosition = iota
+	// BodyFirst makes the script the first child of <body>
+	BodyFirst
+	// BodyLast makes the script the last child of <body>, so it runs once
+	// the rest of the body has been parsed
+	BodyLast
+	// AfterElementID places the script right after the element whose id is
+	// InjectConfig.TargetID, so it runs once that element exists
+	AfterElementID
+)
+
+// ScriptLoading is how the browser loads an external polyfill script
+type ScriptLoading int
+
+const (
+	// LoadBlocking runs the script as soon as it is parsed, blocking
+	// rendering until it has loaded. This is the default.
+	LoadBlocking ScriptLoading = iota
+	// LoadDefer runs the script once the document has been parsed, in order
+	// with other deferred scripts
+	LoadDefer
+	// LoadAsync runs the script as soon as it has loaded, in no particular
+	// order relative to other scripts
+	LoadAsync
+)
+
+// InjectConfig controls how the polyfill script is injected
+type InjectConfig struct {
+	// Position is where the script goes. If the page lacks the target of a
+	// position other than HeadFirst, the page is returned unchanged.
+	Position InjectPosition
+	// TargetID is the element id used by AfterElementID
+	TargetID string
+
+	// Inline embeds PolyfillJS in the page. Otherwise the script references
+	// SrcPath, which keeps responses small and lets browsers cache the
+	// polyfill across navigations.
+	Inline bool
+	// SrcPath is the URL of the served polyfill when Inline is false;
+	// empty uses DefaultPolyfillPath.
+	SrcPath string
+	// Loading adds defer or async to an external script. Browsers ignore
+	// both on inline scripts, which always block, so it has no effect when
+	// Inline is set.
+	Loading ScriptLoading
+	// Nonce, when non-empty, is set as the script's nonce attribute for
+	// pages with a nonce-based Content-Security-Policy.
+	Nonce string
+}
+
+// IsHTMLContentType checks if the Content-Type header indicates HTML content
+// It properly handles media type parsing with parameters like charset
+func IsHTMLContentType(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+
+	// Parse the media type and parameters
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// If parsing fails, do a simple case-insensitive check for "text/html"
+		return strings.HasPrefix(strings.ToLower(contentType), "text/html")
+	}
+
+	// Check if the media type is HTML
+	return mediaType == "text/html"
+}
+
+// InjectHTMLIfHTML injects the polyfill only when contentType is text/html.
+// Anything else, such as CSS, JSON or binary responses, is returned untouched;
+// the HTML parser would otherwise wrap it in a document and corrupt it.
+func InjectHTMLIfHTML(body []byte, contentType string) []byte {
+	if !IsHTMLContentType(contentType) {
+		return body
+	}
+	return InjectHTML(body)
+}
 
 func InjectHTML(body []byte) []byte {
-	doc, err := html.Parse(bytes.NewReader(body))
+	return InjectHTMLWithNonce(body, "")
+}
+
+// InjectHTMLWithNonce is like InjectHTML but sets nonce on the injected script
+// when non-empty, so pages whose Content-Security-Policy only allows scripts
+// with a matching 'nonce-...' source still run the polyfill.
+func InjectHTMLWithNonce(body []byte, nonce string) []byte {
+	return InjectHTMLWithConfig(body, InjectConfig{Inline: true, Nonce: nonce})
+}
+
+// InjectHTMLFragment is InjectHTML for a fragment meant to be spliced into an
+// existing page rather than served as a document: it is parsed in the context
+// of a <body> and rendered back without the <html>, <head> and <body>
+// wrappers a document would gain, with the inline polyfill script before the
+// fragment's nodes. Markup only valid in another context, such as bare table
+// rows, is parsed as a body would parse it. A fragment already carrying the
+// polyfill is returned unchanged.
+func InjectHTMLFragment(body []byte) []byte {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(bytes.NewReader(body), context)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse HTML fragment")
+		return body
+	}
+
+	for _, node := range nodes {
+		if hasPolyfill(node) {
+			return body
+		}
+	}
+
+	var buf bytes.Buffer
+	nodes = append([]*html.Node{polyfillScript(InjectConfig{Inline: true})}, nodes...)
+	for _, node := range nodes {
+		if err := html.Render(&buf, node); err != nil {
+			log.Error().Err(err).Msg("Failed to render HTML fragment")
+			return body
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// hasPolyfill reports whether node or any of its descendants is a polyfill
+// script injected earlier
+func hasPolyfill(node *html.Node) bool {
+	if node.Type == html.ElementNode && node.Data == "script" && isPolyfillScript(node) {
+		return true
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if hasPolyfill(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// InjectHTMLWithConfig injects the polyfill script into body as cfg describes
+func InjectHTMLWithConfig(body []byte, cfg InjectConfig) []byte {
+	// The parser treats a byte order mark as text, which pushes a following
+	// doctype out of place and drops it; parse without it and put it back
+	src, bom := bytes.CutPrefix(body, utf8BOM)
+
+	doc, err := html.Parse(bytes.NewReader(src))
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to parse HTML")
 		return body
 	}
+	ensureDoctype(doc, src)
 
 	// Find the head or body element
 	var head *html.Node
 	var bodyNode *html.Node
+	var target *html.Node
+	var hasCSP bool
+	var injected bool
 	var crawler func(*html.Node)
 	crawler = func(node *html.Node) {
-		if node.Type == html.ElementNode {
+		// Only match HTML elements; an SVG or MathML element can share the
+		// name or id but injecting a script there produces invalid markup
+		if node.Type == html.ElementNode && node.Namespace == "" {
+			if target == nil && cfg.TargetID != "" && hasID(node, cfg.TargetID) {
+				target = node
+			}
 			switch node.Data {
 			case "head":
 				head = node
 			case "body":
 				bodyNode = node
+			case "meta":
+				if isCSPMeta(node) {
+					hasCSP = true
+				}
+			case "script":
+				if isPolyfillScript(node) {
+					injected = true
+				}
 			}
 		}
 		for child := node.FirstChild; child != nil; child = child.NextSibling {
@@ -38,39 +213,60 @@ func InjectHTML(body []byte) []byte {
 	}
 	crawler(doc)
 
-	// Create script element
-	script := &html.Node{
-		Type: html.ElementNode,
-		Data: "script",
-		Attr: []html.Attribute{},
+	// Already carries the polyfill, e.g. a page re-fetched through the portal;
+	// a second copy would initialize the runtime twice
+	if injected {
+		return body
 	}
 
-	// Add the script content
-	scriptContent := &html.Node{
-		Type: html.TextNode,
-		Data: string(polyfillJS),
+	if hasCSP && cfg.Nonce == "" {
+		log.Warn().Msg("Page sets a Content-Security-Policy but no nonce was given; the injected polyfill may be blocked")
 	}
-	script.AppendChild(scriptContent)
 
-	// Inject into head if available, otherwise into body
-	if head != nil {
-		// Insert as the first child of head
-		if head.FirstChild != nil {
+	script := polyfillScript(cfg)
+
+	switch cfg.Position {
+	case HeadFirst:
+		// Inject into head if available, otherwise into body
+		if head != nil {
 			head.InsertBefore(script, head.FirstChild)
+		} else if bodyNode != nil {
+			bodyNode.InsertBefore(script, bodyNode.FirstChild)
 		} else {
-			head.AppendChild(script)
+			// html.Parse normally implies both, but don't drop the script if not
+			log.Warn().Msg("Document has no <head> or <body>, injecting the polyfill at its root")
+			root := doc
+			for c := doc.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && c.Data == "html" {
+					root = c
+					break
+				}
+			}
+			root.InsertBefore(script, root.FirstChild)
+		}
+	case BodyFirst, BodyLast:
+		if bodyNode == nil {
+			log.Warn().Msg("No <body> to inject the polyfill into")
+			return body
 		}
-	} else if bodyNode != nil {
-		// Insert as the first child of body if head doesn't exist
-		if bodyNode.FirstChild != nil {
+		if cfg.Position == BodyFirst {
 			bodyNode.InsertBefore(script, bodyNode.FirstChild)
 		} else {
 			bodyNode.AppendChild(script)
 		}
+	case AfterElementID:
+		if target == nil || target.Parent == nil {
+			log.Warn().Str("id", cfg.TargetID).Msg("No element with the target id to inject the polyfill after")
+			return body
+		}
+		target.Parent.InsertBefore(script, target.NextSibling)
 	}
 
 	// Convert back to bytes
 	var buf bytes.Buffer
+	if bom {
+		buf.Write(utf8BOM)
+	}
 	if err := html.Render(&buf, doc); err != nil {
 		log.Error().Err(err).Msg("Failed to render HTML")
 		return body
@@ -78,3 +274,229 @@ func InjectHTML(body []byte) []byte {
 
 	return buf.Bytes()
 }
+
+// polyfillScript creates the script element injected as cfg describes
+func polyfillScript(cfg InjectConfig) *html.Node {
+	script := &html.Node{
+		Type: html.ElementNode,
+		Data: "script",
+		Attr: []html.Attribute{{Key: polyfillMarker, Val: "1"}},
+	}
+	if cfg.Nonce != "" {
+		script.Attr = append(script.Attr, html.Attribute{Key: "nonce", Val: cfg.Nonce})
+	}
+
+	if cfg.Inline {
+		// Add the script content
+		scriptContent := &html.Node{
+			Type: html.TextNode,
+			Data: string(PolyfillJS),
+		}
+		script.AppendChild(scriptContent)
+	} else {
+		src := cfg.SrcPath
+		if src == "" {
+			src = DefaultPolyfillPath
+		}
+		script.Attr = append(script.Attr, html.Attribute{Key: "src", Val: src})
+
+		switch cfg.Loading {
+		case LoadDefer:
+			script.Attr = append(script.Attr, html.Attribute{Key: "defer"})
+		case LoadAsync:
+			script.Attr = append(script.Attr, html.Attribute{Key: "async"})
+		}
+	}
+
+	return script
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// ensureDoctype makes sure doc renders with a doctype if src starts with one,
+// since losing it switches the browser into quirks mode
+func ensureDoctype(doc *html.Node, src []byte) {
+	for child := doc.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.DoctypeNode {
+			return
+		}
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(src))
+	for {
+		switch z.Next() {
+		case html.DoctypeToken:
+			// Render writes Data verbatim after "<!DOCTYPE ", so the raw
+			// token keeps any public and system identifiers
+			doctype := &html.Node{Type: html.DoctypeNode, Data: string(z.Text())}
+			doc.InsertBefore(doctype, doc.FirstChild)
+			return
+		case html.CommentToken:
+			continue
+		case html.TextToken:
+			if len(bytes.TrimSpace(z.Text())) == 0 {
+				continue
+			}
+		}
+		// a doctype only counts before any content
+		return
+	}
+}
+
+// polyfillMarker is the attribute that marks the injected script
+const polyfillMarker = "data-portal-polyfill"
+
+// isPolyfillScript reports whether node is a polyfill script injected earlier
+func isPolyfillScript(node *html.Node) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == polyfillMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// hasID reports whether node's id attribute is id
+func hasID(node *html.Node, id string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == "id" && attr.Val == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isCSPMeta reports whether node is a <meta http-equiv="Content-Security-Policy">
+func isCSPMeta(node *html.Node) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == "http-equiv" && strings.EqualFold(attr.Val, "content-security-policy") {
+			return true
+		}
+	}
+	return false
+}
+
+// InjectHTMLStream copies r to w, inserting the polyfill script right after the
+// first <head> start tag (or <body>, if it comes first). Fragments and pages
+// without either get the script before their first element instead, where
+// browsers open the implied head or body, or at the end if there is no
+// element at all. It scans with the HTML tokenizer, so tags inside comments,
+// scripts or attribute values are not mistaken for the injection point, and
+// writes every token out verbatim as soon as it is read. Only the current
+// token is buffered, which keeps memory flat and lets large or chunked
+// responses stream through as they arrive.
+//
+// Streaming limits it to InjectHTML's defaults: the script is always inline,
+// without a nonce, and goes where HeadFirst puts it. A page already carrying
+// the polyfill is only recognized when the script sits at the injection
+// point, past whitespace and comments, since the rest of the page has not
+// been read yet. Use InjectHTMLWithConfig when any of that matters.
+func InjectHTMLStream(r io.Reader, w io.Writer) error {
+	z := html.NewTokenizer(r)
+	found := false
+	// the script is written before the token following the injection point,
+	// unless that token is the polyfill itself
+	pending := false
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if !found {
+				log.Warn().Msg("Document has no elements, appending the polyfill at the end")
+				pending = true
+			}
+			if pending {
+				if err := writeScript(w); err != nil {
+					return err
+				}
+			}
+			if err := z.Err(); err != io.EOF {
+				return err
+			}
+			return nil
+		}
+
+		var name []byte
+		var hasAttr bool
+		if tt == html.StartTagToken {
+			name, hasAttr = z.TagName()
+		}
+		isPolyfill := string(name) == "script" && hasPolyfillAttr(z, hasAttr)
+
+		if !found && tt == html.StartTagToken && !isInjectionTag(name) && string(name) != "html" {
+			found = true
+			if !isPolyfill {
+				log.Warn().Msg("Document has no <head> or <body>, injecting the polyfill before its first element")
+				pending = true
+			}
+		}
+
+		if pending && isBlankToken(z, tt) {
+			// keep looking for an injected polyfill, which may follow these
+			if _, err := w.Write(z.Raw()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if pending {
+			pending = false
+			if !isPolyfill {
+				if err := writeScript(w); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := w.Write(z.Raw()); err != nil {
+			return err
+		}
+
+		if !found && tt == html.StartTagToken && isInjectionTag(name) {
+			found = true
+			pending = true
+		}
+	}
+}
+
+// isBlankToken reports whether the current token is a comment or whitespace
+// only text, which leaves the injection point where it is
+func isBlankToken(z *html.Tokenizer, tt html.TokenType) bool {
+	switch tt {
+	case html.CommentToken:
+		return true
+	case html.TextToken:
+		return len(bytes.TrimLeft(z.Raw(), " \t\r\n\f")) == 0
+	}
+	return false
+}
+
+func isInjectionTag(name []byte) bool {
+	return string(name) == "head" || string(name) == "body"
+}
+
+// hasPolyfillAttr reports whether the current start tag carries the polyfill
+// marker, i.e. is a polyfill script injected earlier. hasAttr is what
+// TagName returned.
+func hasPolyfillAttr(z *html.Tokenizer, hasAttr bool) bool {
+	for hasAttr {
+		var key []byte
+		key, _, hasAttr = z.TagAttr()
+		if string(key) == polyfillMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// writeScript writes the inline polyfill script element
+func writeScript(w io.Writer) error {
+	if _, err := io.WriteString(w, `<script `+polyfillMarker+`="1">`); err != nil {
+		return err
+	}
+	if _, err := w.Write(PolyfillJS); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "</script>")
+	return err
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// polyfillScripts returns the polyfill script elements in doc, in order
+func polyfillScripts(doc *html.Node) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "script" && isPolyfillScript(node) {
+			found = append(found, node)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return found
+}
+
+func parseDoc(t *testing.T, src []byte) *html.Node {
+	t.Helper()
+
+	// the parser takes a byte order mark for text, see InjectHTMLWithConfig
+	src, _ = bytes.CutPrefix(src, utf8BOM)
+	doc, err := html.Parse(bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse output: %v", err)
+	}
+	return doc
+}
+
+func TestInjectHTMLIdempotent(t *testing.T) {
+	page := []byte("<!DOCTYPE html><html><head><title>t</title></head><body><p>hi</p></body></html>")
+
+	once := InjectHTML(page)
+	twice := InjectHTML(once)
+
+	if n := len(polyfillScripts(parseDoc(t, twice))); n != 1 {
+		t.Fatalf("found %d polyfill scripts after injecting twice, want 1", n)
+	}
+	if !bytes.Equal(once, twice) {
+		t.Fatal("second injection changed the page")
+	}
+}
+
+// scriptParent returns the element name the only polyfill script sits in
+func scriptParent(t *testing.T, out []byte) string {
+	t.Helper()
+
+	scripts := polyfillScripts(parseDoc(t, out))
+	if len(scripts) != 1 {
+		t.Fatalf("found %d polyfill scripts, want 1:\n%s", len(scripts), out)
+	}
+	return scripts[0].Parent.Data
+}
+
+func TestInjectHTMLKeepsDoctype(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		page    string
+		doctype string
+	}{
+		{
+			name:    "html5",
+			page:    "<!DOCTYPE html>\n<html><head><title>t</title></head><body></body></html>",
+			doctype: "<!DOCTYPE html>",
+		},
+		{
+			name: "xhtml",
+			page: `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">` +
+				`<html xmlns="http://www.w3.org/1999/xhtml"><head><title>t</title></head><body></body></html>`,
+			doctype: `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`,
+		},
+		{
+			name:    "bom",
+			page:    "\ufeff<!DOCTYPE html><html><head></head><body></body></html>",
+			doctype: "\ufeff<!DOCTYPE html>",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := InjectHTML([]byte(tc.page))
+
+			if !bytes.HasPrefix(out, []byte(tc.doctype)) {
+				t.Fatalf("output does not start with %q:\n%.200s", tc.doctype, out)
+			}
+			if parent := scriptParent(t, out); parent != "head" {
+				t.Fatalf("polyfill injected into <%s>, want <head>", parent)
+			}
+		})
+	}
+}
+
+func TestInjectHTMLSkipsForeignContent(t *testing.T) {
+	page := []byte(`<!DOCTYPE html><html><head><title>t</title></head><body>` +
+		`<svg><head id="svg-head"></head><foreignObject><body></body></foreignObject></svg>` +
+		`<math><head></head></math></body></html>`)
+
+	out := InjectHTML(page)
+
+	scripts := polyfillScripts(parseDoc(t, out))
+	if len(scripts) != 1 {
+		t.Fatalf("found %d polyfill scripts, want 1", len(scripts))
+	}
+	for node := scripts[0]; node != nil; node = node.Parent {
+		if node.Namespace != "" {
+			t.Fatalf("polyfill injected into foreign content <%s:%s>", node.Namespace, node.Data)
+		}
+	}
+	if parent := scripts[0].Parent; parent.Data != "head" || parent.Parent.Data != "html" {
+		t.Fatalf("polyfill injected into <%s>, want the document <head>", parent.Data)
+	}
+}
+
+func attr(node *html.Node, key string) (string, bool) {
+	for _, a := range node.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func TestInjectHTMLScriptLoading(t *testing.T) {
+	page := []byte(`<html><head><script src="/a.js"></script><script src="/b.js" defer></script></head><body></body></html>`)
+
+	for _, tc := range []struct {
+		name    string
+		loading ScriptLoading
+		want    string // attribute that must be set, if any
+	}{
+		{"blocking", LoadBlocking, ""},
+		{"defer", LoadDefer, "defer"},
+		{"async", LoadAsync, "async"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := InjectHTMLWithConfig(page, InjectConfig{Loading: tc.loading})
+			doc := parseDoc(t, out)
+
+			scripts := polyfillScripts(doc)
+			if len(scripts) != 1 {
+				t.Fatalf("found %d polyfill scripts, want 1", len(scripts))
+			}
+			script := scripts[0]
+			if src, _ := attr(script, "src"); src != DefaultPolyfillPath {
+				t.Fatalf("src = %q, want %q", src, DefaultPolyfillPath)
+			}
+			for _, key := range []string{"defer", "async"} {
+				if _, ok := attr(script, key); ok != (key == tc.want) {
+					t.Fatalf("%s attribute present = %v, want %v", key, ok, key == tc.want)
+				}
+			}
+
+			// the polyfill goes first and the page's scripts keep their order
+			var order []string
+			for node := script.Parent.FirstChild; node != nil; node = node.NextSibling {
+				if node.Type != html.ElementNode || node.Data != "script" {
+					continue
+				}
+				if node == script {
+					order = append(order, "polyfill")
+				} else {
+					src, _ := attr(node, "src")
+					order = append(order, src)
+				}
+			}
+			if got := strings.Join(order, ","); got != "polyfill,/a.js,/b.js" {
+				t.Fatalf("head scripts in order %s, want polyfill,/a.js,/b.js", got)
+			}
+		})
+	}
+}
+
+func TestInjectHTMLInlineIgnoresLoading(t *testing.T) {
+	out := InjectHTMLWithConfig([]byte("<html><head></head></html>"), InjectConfig{Inline: true, Loading: LoadDefer})
+
+	scripts := polyfillScripts(parseDoc(t, out))
+	if len(scripts) != 1 {
+		t.Fatalf("found %d polyfill scripts, want 1", len(scripts))
+	}
+	if _, ok := attr(scripts[0], "defer"); ok {
+		t.Fatal("inline polyfill carries defer, which browsers ignore")
+	}
+}
+
+func injectStream(t *testing.T, page string) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := InjectHTMLStream(strings.NewReader(page), &out); err != nil {
+		t.Fatalf("InjectHTMLStream: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestInjectWithoutHeadOrBody(t *testing.T) {
+	for _, page := range []string{"<div>foo</div>", "plain text", "<html><div>x</div></html>"} {
+		t.Run(page, func(t *testing.T) {
+			if n := len(polyfillScripts(parseDoc(t, InjectHTML([]byte(page))))); n != 1 {
+				t.Fatalf("InjectHTML: found %d polyfill scripts, want 1", n)
+			}
+
+			out := injectStream(t, page)
+			if n := len(polyfillScripts(parseDoc(t, out))); n != 1 {
+				t.Fatalf("InjectHTMLStream: found %d polyfill scripts, want 1:\n%s", n, out)
+			}
+			var script bytes.Buffer
+			writeScript(&script)
+			if rest := bytes.Replace(out, script.Bytes(), nil, 1); string(rest) != page {
+				t.Fatalf("InjectHTMLStream changed the page itself:\n%s", rest)
+			}
+			if again := injectStream(t, string(out)); !bytes.Equal(again, out) {
+				t.Fatal("InjectHTMLStream injected a second copy")
+			}
+		})
+	}
+}
+
+func TestInjectHTMLStreamIdempotent(t *testing.T) {
+	var script bytes.Buffer
+	writeScript(&script)
+
+	for _, gap := range []string{"", "\n  ", "<!-- polyfill -->", "\n<!-- a -->\n"} {
+		t.Run(fmt.Sprintf("%q", gap), func(t *testing.T) {
+			page := "<html><head>" + gap + script.String() + "<title>t</title></head><body></body></html>"
+			if out := injectStream(t, page); string(out) != page {
+				t.Fatalf("InjectHTMLStream changed an injected page:\n%s", out)
+			}
+		})
+	}
+
+	// blank tokens alone don't hide a missing polyfill
+	out := injectStream(t, "<html><head>\n  <!-- c --><title>t</title></head></html>")
+	if n := len(polyfillScripts(parseDoc(t, out))); n != 1 {
+		t.Fatalf("found %d polyfill scripts, want 1:\n%s", n, out)
+	}
+}
+
+func TestInjectHTMLStreamDiv(t *testing.T) {
+	out := injectStream(t, "<div>foo</div>")
+
+	// the script goes before the first element, where the implied head opens
+	if !bytes.HasPrefix(out, []byte("<script "+polyfillMarker+`="1">`)) || !bytes.HasSuffix(out, []byte("<div>foo</div>")) {
+		t.Fatalf("unexpected output:\n%.200s", out)
+	}
+}
+
+func TestInjectHTMLFragment(t *testing.T) {
+	in := []byte("<p>hello <b>world</b></p>")
+	out := InjectHTMLFragment(in)
+
+	for _, tag := range []string{"<html", "<head", "<body"} {
+		if bytes.Contains(out, []byte(tag)) {
+			t.Fatalf("fragment output gained a %s> wrapper:\n%s", tag, out)
+		}
+	}
+
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(bytes.NewReader(out), context)
+	if err != nil {
+		t.Fatalf("parse output: %v", err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("output has %d top-level nodes, want the script and the <p>", len(nodes))
+	}
+	if nodes[0].Data != "script" || !isPolyfillScript(nodes[0]) {
+		t.Fatalf("first node is <%s>, want the polyfill script", nodes[0].Data)
+	}
+
+	var rest bytes.Buffer
+	html.Render(&rest, nodes[1])
+	if !bytes.Equal(rest.Bytes(), in) {
+		t.Fatalf("fragment rendered as %q, want %q", rest.Bytes(), in)
+	}
+
+	if again := InjectHTMLFragment(out); !bytes.Equal(again, out) {
+		t.Fatal("second injection changed the fragment")
+	}
+}
+
+func TestInjectHTMLAfterElementID(t *testing.T) {
+	cfg := InjectConfig{Position: AfterElementID, TargetID: "app", Inline: true}
+
+	// the SVG element shares the id but is not a place for a script
+	page := []byte(`<html><body><svg><g id="app"></g></svg><div id="app"></div><p>end</p></body></html>`)
+	scripts := polyfillScripts(parseDoc(t, InjectHTMLWithConfig(page, cfg)))
+	if len(scripts) != 1 {
+		t.Fatalf("found %d polyfill scripts, want 1", len(scripts))
+	}
+	if prev := scripts[0].PrevSibling; prev == nil || prev.Data != "div" {
+		t.Fatal("polyfill script does not follow the HTML element with the target id")
+	}
+
+	svgOnly := []byte(`<html><body><svg><g id="app"></g></svg></body></html>`)
+	if out := InjectHTMLWithConfig(svgOnly, cfg); !bytes.Equal(out, svgOnly) {
+		t.Fatalf("injected after a foreign element:\n%s", out)
+	}
+}
+
+// chunkReader hands out its chunks one Read at a time, so a test controls
+// where the input is split
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+// renderDoc parses src and renders it back, so outputs that serialize the
+// same document differently compare equal
+func renderDoc(t *testing.T, src []byte) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := html.Render(&out, parseDoc(t, src)); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return out.String()
+}
+
+func TestInjectHTMLStreamSplitInput(t *testing.T) {
+	page := `<!DOCTYPE html><html lang="en"><head><meta charset="utf-8"><title>t</title></head><body><p class="x">hi</p></body></html>`
+	whole := injectStream(t, page)
+	want := renderDoc(t, InjectHTML([]byte(page)))
+	if got := renderDoc(t, whole); got != want {
+		t.Fatalf("InjectHTMLStream disagrees with InjectHTML:\n%s\nwant:\n%s", got, want)
+	}
+
+	cut := func(marks ...string) []string {
+		var chunks []string
+		rest := page
+		for _, mark := range marks {
+			i := strings.Index(rest, mark)
+			if i < 0 {
+				t.Fatalf("%q not in the rest of the page", mark)
+			}
+			i += len(mark)
+			chunks = append(chunks, rest[:i])
+			rest = rest[i:]
+		}
+		return append(chunks, rest)
+	}
+	readers := map[string]io.Reader{
+		"one byte":      iotest.OneByteReader(strings.NewReader(page)),
+		"mid tag":       &chunkReader{chunks: cut("<he", "<ti")},
+		"mid attribute": &chunkReader{chunks: cut(`<meta cha`, `rset="ut`, `class=`)},
+	}
+	for name, r := range readers {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := InjectHTMLStream(r, &out); err != nil {
+				t.Fatalf("InjectHTMLStream: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), whole) {
+				t.Fatalf("split input gave\n%s\nwant\n%s", out.Bytes(), whole)
+			}
+			if got := renderDoc(t, out.Bytes()); got != want {
+				t.Fatalf("InjectHTMLStream disagrees with InjectHTML:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
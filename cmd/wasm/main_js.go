@@ -8,12 +8,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall/js"
@@ -24,6 +24,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/idna"
 	"gosuda.org/portal-web/internal/httpjs"
+	"gosuda.org/portal-web/internal/wsjs"
 	"gosuda.org/portal/sdk"
 )
 
@@ -117,6 +118,8 @@ var client = &http.Client{
 
 type Proxy struct {
 	wsManager *WebSocketManager
+	// inject is how the polyfill goes into proxied pages
+	inject InjectConfig
 }
 
 // WebSocket connection manager
@@ -337,24 +340,6 @@ func (c *WSConnection) Close() {
 	})
 }
 
-// IsHTMLContentType checks if the Content-Type header indicates HTML content
-// It properly handles media type parsing with parameters like charset
-func IsHTMLContentType(contentType string) bool {
-	if contentType == "" {
-		return false
-	}
-
-	// Parse the media type and parameters
-	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		// If parsing fails, do a simple case-insensitive check for "text/html"
-		return strings.HasPrefix(strings.ToLower(contentType), "text/html")
-	}
-
-	// Check if the media type is HTML
-	return mediaType == "text/html"
-}
-
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Handle WebSocket polyfill endpoints
 	if strings.HasPrefix(r.URL.Path, "/sw-cgi/websocket/") {
@@ -362,6 +347,12 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !p.inject.Inline && r.URL.Path == p.polyfillPath() {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write(PolyfillJS)
+		return
+	}
+
 	log.Info().Msgf("Proxying request to %s", r.URL.String())
 
 	r = r.Clone(context.Background())
@@ -383,13 +374,19 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if IsHTMLContentType(resp.Header.Get("Content-Type")) {
-		w.WriteHeader(resp.StatusCode)
+		// Buffered rather than streamed: InjectHTMLStream only does the
+		// default injection and can't see a polyfill further in the page
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to read response body")
+			http.Error(w, fmt.Sprintf("Failed to read response body, err: %v", err), http.StatusBadGateway)
 			return
 		}
-		body = InjectHTML(body)
+		body = InjectHTMLWithConfig(body, p.inject)
+
+		// The injected script changes the length
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(resp.StatusCode)
 		w.Write(body)
 		return
 	}
@@ -398,6 +395,14 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, resp.Body)
 }
 
+// polyfillPath is where the proxy serves the polyfill for pages referencing it
+func (p *Proxy) polyfillPath() string {
+	if p.inject.SrcPath != "" {
+		return p.inject.SrcPath
+	}
+	return DefaultPolyfillPath
+}
+
 func (p *Proxy) handleWebSocketPolyfill(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 
@@ -799,6 +804,10 @@ func main() {
 	// Override if LEASE_ID is set
 	leaseID = os.Getenv("LEASE_ID")
 
+	if ok, reason := wsjs.Supported(); !ok {
+		log.Error().Str("reason", reason).Msg("WebSocket is not supported in this environment, relay connections will fail")
+	}
+
 	// Get bootstrap servers from global JavaScript variable
 	bootstrapServerList := getBootstrapServers()
 
@@ -806,7 +815,7 @@ func main() {
 
 	rdClient, err = sdk.NewClient(
 		sdk.WithBootstrapServers(bootstrapServerList),
-		sdk.WithDialer(WebSocketDialerJS()),
+		sdk.WithDialer(WebSocketDialerJS(nil)),
 	)
 	if err != nil {
 		panic(err)
@@ -817,6 +826,7 @@ func main() {
 	wsManager := NewWebSocketManager()
 	proxy := &Proxy{
 		wsManager: wsManager,
+		inject:    InjectConfig{Inline: true},
 	}
 
 	// Expose HTTP handler to JavaScript as __go_jshttp
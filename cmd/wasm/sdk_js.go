@@ -3,20 +3,187 @@ package main
 import (
 	"context"
 	"io"
+	"sync"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"gosuda.org/portal-web/internal/wsjs"
 )
 
-// WebSocketDialerJS creates a WebSocket dialer function for JavaScript/WebAssembly environment
-func WebSocketDialerJS() func(context.Context, string) (io.ReadWriteCloser, error) {
+// WebSocketDialerJS creates a WebSocket dialer function for JavaScript/WebAssembly environment.
+// Connections are opened with d's options; a nil d uses the defaults.
+// The returned streams implement IsAlive() bool and Ping(context.Context)
+// error for connection pools that type-assert; Ping needs d to configure a
+// keepalive.
+func WebSocketDialerJS(d *wsjs.Dialer) func(context.Context, string) (io.ReadWriteCloser, error) {
+	if d == nil {
+		d = &wsjs.Dialer{}
+	}
+
+	// When a redial of each URL may go ahead, from the retry hint the server
+	// sent the last connection; only URLs with a pending hint have an entry
+	var retryAtMu sync.Mutex
+	retryAt := make(map[string]time.Time)
+
 	return func(ctx context.Context, url string) (io.ReadWriteCloser, error) {
+		// Browsers refuse ws:// from an https:// page
+		url, err := wsjs.NormalizeURL(url)
+		if err != nil {
+			return nil, err
+		}
+
+		retryAtMu.Lock()
+		at, hinted := retryAt[url]
+		delete(retryAt, url)
+		retryAtMu.Unlock()
+
+		if delay := time.Until(at); hinted && delay > 0 {
+			log.Info().Str("url", url).Dur("delay", delay).Msg("[Dialer] Server asked to retry later, waiting before redial")
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
 		// Use the wsjs package to create a WebSocket connection
-		conn, err := wsjs.Dial(url)
+		conn, err := d.Dial(ctx, url)
 		if err != nil {
 			return nil, err
 		}
 
+		// Keep only the hint, so closed connections aren't held on to
+		go func() {
+			<-conn.Done()
+			if delay, ok := conn.RetryAfter(); ok {
+				retryAtMu.Lock()
+				retryAt[url] = time.Now().Add(delay)
+				retryAtMu.Unlock()
+			}
+		}()
+
 		// Wrap the WebSocket connection with WsStream for io.ReadWriteCloser interface
 		return wsjs.NewWsStream(conn), nil
 	}
 }
+
+// ReconnectingWebSocketDialerJS is like WebSocketDialerJS(d) but retries a
+// failed dial, waiting backoff(attempt) between attempts, until ctx is done or
+// maxRetries retries have failed (0 or negative retries forever). A nil
+// backoff uses wsjs.DefaultBackoff.
+//
+// Only establishing the connection is retried: the returned stream does not
+// reconnect once open, and fails like any other when the connection drops.
+// Recovering from that is up to the caller, e.g. by dialing again.
+func ReconnectingWebSocketDialerJS(d *wsjs.Dialer, maxRetries int, backoff func(attempt int) time.Duration) func(context.Context, string) (io.ReadWriteCloser, error) {
+	if backoff == nil {
+		backoff = wsjs.DefaultBackoff
+	}
+	dial := WebSocketDialerJS(d)
+
+	return func(ctx context.Context, url string) (io.ReadWriteCloser, error) {
+		for attempt := 1; ; attempt++ {
+			conn, err := dial(ctx, url)
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if maxRetries > 0 && attempt > maxRetries {
+				return nil, err
+			}
+
+			delay := backoff(attempt)
+			log.Warn().Err(err).Str("url", url).Int("attempt", attempt).Dur("delay", delay).Msg("[Dialer] Dial failed, retrying")
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
+
+// WebSocketDialerJSWithStats is like WebSocketDialerJS(d) but reports each
+// connection's traffic counters to sink every interval (if positive) and once
+// more, final, when the connection is closed by either end. A nil sink
+// returns the plain WebSocketDialerJS(d), so there is no cost without one.
+// Either way the returned streams expose Stats() wsjs.ConnStats for callers
+// that type-assert.
+func WebSocketDialerJSWithStats(d *wsjs.Dialer, sink func(wsjs.ConnStats), interval time.Duration) func(context.Context, string) (io.ReadWriteCloser, error) {
+	dial := WebSocketDialerJS(d)
+	if sink == nil {
+		return dial
+	}
+
+	return func(ctx context.Context, url string) (io.ReadWriteCloser, error) {
+		rwc, err := dial(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &statsStream{
+			WsStream: rwc.(*wsjs.WsStream),
+			sink:     sink,
+			stop:     make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		go s.report(interval)
+		return s, nil
+	}
+}
+
+// statsStream reports a stream's traffic counters to a sink
+type statsStream struct {
+	*wsjs.WsStream
+
+	sink      func(wsjs.ConnStats)
+	stop      chan struct{}
+	done      chan struct{} // closed once periodic reports have stopped
+	stopOnce  sync.Once
+	finalOnce sync.Once
+}
+
+// report sends the counters every interval, if positive, until the stream is
+// closed, and the final ones if the connection closes on its own
+func (s *statsStream) report(interval time.Duration) {
+	defer close(s.done)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			s.sink(s.Stats())
+		case <-s.Done():
+			s.final()
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// final reports the final counters, once
+func (s *statsStream) final() {
+	s.finalOnce.Do(func() {
+		s.sink(s.Stats())
+	})
+}
+
+// Close closes the stream and reports the final counters
+func (s *statsStream) Close() error {
+	err := s.WsStream.Close()
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+	s.final()
+	return err
+}
@@ -0,0 +1,42 @@
+package wsjs
+
+import (
+	"io"
+)
+
+// Bridge relays data between a and b in both directions until either
+// direction stops, then closes both streams and waits for the other
+// direction to finish.
+//
+// Both streams are closed exactly once, by Bridge, and no goroutine
+// outlives the call. The first error encountered by either direction is
+// returned. A direction that ends with io.EOF, or with a normal close of
+// the connection (CloseNormal or CloseGoingAway from the peer, or ErrClosed
+// after a local Close), counts as a clean shutdown and reports nil. Errors
+// the other direction hits while being torn down are discarded.
+func Bridge(a, b io.ReadWriteCloser) error {
+	errCh := make(chan error, 2)
+
+	go func() {
+		_, err := io.Copy(a, b)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(b, a)
+		errCh <- err
+	}()
+
+	first := <-errCh
+
+	a.Close()
+	b.Close()
+
+	// Closing both ends unblocks the other direction
+	<-errCh
+
+	// io.Copy already reports io.EOF as nil
+	if isNormalClose(first) {
+		return nil
+	}
+	return first
+}
@@ -0,0 +1,201 @@
+package wsjs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeStream is one end of a relayed transport: the test feeds what it reads
+// through feed, and what is written to it is collected in written
+type fakeStream struct {
+	r    *io.PipeReader
+	feed *io.PipeWriter
+
+	mu      sync.Mutex
+	written bytes.Buffer
+	closed  bool
+
+	closes atomic.Int32
+}
+
+func newFakeStream() *fakeStream {
+	r, w := io.Pipe()
+	return &fakeStream{r: r, feed: w}
+}
+
+func (f *fakeStream) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *fakeStream) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return f.written.Write(p)
+}
+
+func (f *fakeStream) Close() error {
+	f.closes.Add(1)
+
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	// unblock a pending Read like a closed connection would
+	f.r.CloseWithError(io.ErrClosedPipe)
+	return nil
+}
+
+func (f *fakeStream) output() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written.String()
+}
+
+// runBridge runs Bridge(a, b) in the background and returns its result channel
+func runBridge(a, b *fakeStream) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- Bridge(a, b)
+	}()
+	return done
+}
+
+func waitBridge(t *testing.T, done <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Bridge did not return")
+		return nil
+	}
+}
+
+// checkNoLeak fails if goroutines started during the test are still running
+func checkNoLeak(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running after Bridge, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBridgeCloseOrderings(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// first is the stream whose input ends first
+		first func(a, b *fakeStream) (first, other *fakeStream)
+	}{
+		{"a first", func(a, b *fakeStream) (*fakeStream, *fakeStream) { return a, b }},
+		{"b first", func(a, b *fakeStream) (*fakeStream, *fakeStream) { return b, a }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+
+			a, b := newFakeStream(), newFakeStream()
+			done := runBridge(a, b)
+
+			first, other := tc.first(a, b)
+			if _, err := first.feed.Write([]byte("payload")); err != nil {
+				t.Fatalf("feed: %v", err)
+			}
+			first.feed.Close()
+
+			if err := waitBridge(t, done); err != nil {
+				t.Fatalf("Bridge returned %v, want nil on EOF", err)
+			}
+			if got := other.output(); got != "payload" {
+				t.Fatalf("relayed %q, want %q", got, "payload")
+			}
+			if n := a.closes.Load(); n != 1 {
+				t.Fatalf("a closed %d times, want 1", n)
+			}
+			if n := b.closes.Load(); n != 1 {
+				t.Fatalf("b closed %d times, want 1", n)
+			}
+			checkNoLeak(t, before)
+		})
+	}
+}
+
+func TestBridgeReturnsFirstError(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	a, b := newFakeStream(), newFakeStream()
+	done := runBridge(a, b)
+
+	errBoom := errors.New("boom")
+	b.feed.CloseWithError(errBoom)
+
+	if err := waitBridge(t, done); !errors.Is(err, errBoom) {
+		t.Fatalf("Bridge returned %v, want %v", err, errBoom)
+	}
+	// the teardown error of the other direction must not replace it
+	if a.closes.Load() != 1 || b.closes.Load() != 1 {
+		t.Fatalf("closes = %d, %d, want 1, 1", a.closes.Load(), b.closes.Load())
+	}
+	checkNoLeak(t, before)
+}
+
+func TestBridgeSimultaneousClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	a, b := newFakeStream(), newFakeStream()
+	done := runBridge(a, b)
+
+	a.feed.Close()
+	b.feed.Close()
+
+	if err := waitBridge(t, done); err != nil {
+		t.Fatalf("Bridge returned %v, want nil", err)
+	}
+	if a.closes.Load() != 1 || b.closes.Load() != 1 {
+		t.Fatalf("closes = %d, %d, want 1, 1", a.closes.Load(), b.closes.Load())
+	}
+	checkNoLeak(t, before)
+}
+
+func TestBridgeNormalClose(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		err   error
+		clean bool
+	}{
+		{"normal", &CloseError{Code: CloseNormal, WasClean: true}, true},
+		{"going away", &CloseError{Code: CloseGoingAway, WasClean: true}, true},
+		{"local close", ErrClosed, true},
+		{"abnormal", &CloseError{Code: 1006}, false},
+		{"policy", &CloseError{Code: 1008, Reason: "limit", WasClean: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := newFakeStream(), newFakeStream()
+			done := runBridge(a, b)
+
+			// what a WsStream's Read returns once its connection closes
+			a.feed.CloseWithError(tc.err)
+
+			err := waitBridge(t, done)
+			if tc.clean && err != nil {
+				t.Fatalf("Bridge returned %v, want nil on a normal close", err)
+			}
+			if !tc.clean && err != tc.err {
+				t.Fatalf("Bridge returned %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
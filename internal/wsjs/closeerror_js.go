@@ -0,0 +1,12 @@
+package wsjs
+
+// CloseInfo returns the close code, reason and whether the closing handshake
+// completed cleanly. It returns zero values while the connection is open.
+func (conn *Conn) CloseInfo() (code int, reason string, wasClean bool) {
+	select {
+	case <-conn.closeChan:
+		return conn.closeCode, conn.closeReason, conn.closeClean
+	default:
+		return 0, "", false
+	}
+}
@@ -0,0 +1,99 @@
+package wsjs
+
+import (
+	"time"
+)
+
+// SetWriteCoalesceDelay makes Send hold payloads for up to delay and send
+// everything written in that window as a single binary frame, trading a little
+// latency for fewer frames, like Nagle's algorithm on TCP. Since payloads are
+// merged, this only suits connections used as a byte stream.
+//
+// Flush sends pending data right away, SendPriority bypasses the delay, and
+// Close flushes before closing. An error from a delayed flush is returned by
+// the next Send or Flush. Zero, the default, disables coalescing.
+func (conn *Conn) SetWriteCoalesceDelay(delay time.Duration) {
+	conn.coalesceMu.Lock()
+	conn.coalesceDelay = delay
+	conn.coalesceMu.Unlock()
+
+	if delay <= 0 {
+		conn.Flush()
+	}
+}
+
+// Flush immediately sends data held back by write coalescing
+func (conn *Conn) Flush() error {
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	return conn.flushLocked()
+}
+
+// SendPriority sends data as its own frame right away, bypassing the
+// coalescing delay. Pending coalesced data is flushed first, so frames still
+// go out in the order they were written.
+func (conn *Conn) SendPriority(data []byte) error {
+	if skip, err := conn.checkEmpty(data); skip || err != nil {
+		return err
+	}
+
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	if err := conn.flushLocked(); err != nil {
+		return err
+	}
+	return conn.writeFrame(data)
+}
+
+// coalesce buffers data if coalescing is enabled and reports whether it did
+func (conn *Conn) coalesce(data []byte) (bool, error) {
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	if conn.coalesceDelay <= 0 {
+		return false, nil
+	}
+
+	if err := conn.coalesceErr; err != nil {
+		conn.coalesceErr = nil
+		return true, err
+	}
+	// fail like an unbuffered Send would, rather than buffer for a dead link
+	if err := conn.checkOpen(); err != nil {
+		return true, err
+	}
+
+	conn.pending = append(conn.pending, data...)
+	if conn.coalesceTimer == nil {
+		conn.coalesceTimer = time.AfterFunc(conn.coalesceDelay, func() {
+			conn.coalesceMu.Lock()
+			defer conn.coalesceMu.Unlock()
+
+			if err := conn.flushLocked(); err != nil {
+				conn.coalesceErr = err
+			}
+		})
+	}
+	return true, nil
+}
+
+func (conn *Conn) flushLocked() error {
+	if conn.coalesceTimer != nil {
+		conn.coalesceTimer.Stop()
+		conn.coalesceTimer = nil
+	}
+
+	err := conn.coalesceErr
+	conn.coalesceErr = nil
+
+	if len(conn.pending) > 0 {
+		data := conn.pending
+		conn.pending = nil
+		if werr := conn.writeFrame(data); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package wsjs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCoalesceRejectsClosedConn(t *testing.T) {
+	t.Run("closed locally", func(t *testing.T) {
+		conn := dialTest(t, nil, "sink")
+		conn.SetWriteCoalesceDelay(time.Hour)
+		conn.Close()
+
+		if err := conn.Send([]byte("late")); !errors.Is(err, ErrClosed) {
+			t.Fatalf("Send after Close returned %v, want ErrClosed", err)
+		}
+	})
+
+	t.Run("closed by peer", func(t *testing.T) {
+		conn := dialTest(t, nil, "sink")
+		conn.SetWriteCoalesceDelay(time.Hour)
+		lastSocket().Call("_closed", 1001, "going away", true)
+		waitTick()
+
+		if err := conn.Send([]byte("late")); !errors.Is(err, ErrClosed) {
+			t.Fatalf("Send after the peer closed returned %v, want ErrClosed", err)
+		}
+	})
+}
+
+func TestCoalesceMergesSends(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+	conn.SetWriteCoalesceDelay(time.Hour)
+
+	for _, p := range []string{"a", "b", "c"} {
+		if err := conn.Send([]byte(p)); err != nil {
+			t.Fatalf("Send %q: %v", p, err)
+		}
+	}
+	sent := lastSocket().Get("sent")
+	if n := sent.Length(); n != 0 {
+		t.Fatalf("%d frames sent before Flush, want 0", n)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if n := sent.Length(); n != 1 {
+		t.Fatalf("%d frames sent after Flush, want 1", n)
+	}
+	if got := sent.Index(0).Get("byteLength").Int(); got != 3 {
+		t.Fatalf("merged frame has %d bytes, want 3", got)
+	}
+}
+
+func TestBarrierFlushesCoalesced(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+	conn.SetWriteCoalesceDelay(time.Hour)
+
+	if err := conn.Send([]byte("held")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := conn.Barrier(testContext(t)); err != nil {
+		t.Fatalf("Barrier: %v", err)
+	}
+	if got := sentFrames(); len(got) != 1 || got[0] != "held" {
+		t.Fatalf("sent %q before Barrier returned, want the coalesced data", got)
+	}
+}
@@ -0,0 +1,176 @@
+package wsjs
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// CompressionSubprotocol is offered by DialCompressed; a server selecting it
+// agrees to the CompressedWsStream framing.
+const CompressionSubprotocol = "portal-deflate"
+
+// Every message on a CompressedWsStream starts with one header byte telling
+// whether the rest is the raw payload or a raw DEFLATE stream (RFC 1951) of
+// it. Each message is compressed on its own, without context carried over
+// from earlier messages.
+const (
+	compressRaw     byte = 0
+	compressDeflate byte = 1
+)
+
+// compressMinSize is the smallest payload worth compressing; smaller ones
+// would rarely shrink
+const compressMinSize = 128
+
+var ErrBadCompressedFrame = errors.New("websocket malformed compressed frame")
+
+// CompressedWsStream compresses what is written to a WsStream and inflates
+// what is read from it, since browsers don't let scripts enable the
+// permessage-deflate extension. Every Write goes out as one message. Both
+// ends must use the framing, see DialCompressed. A message inflating past the
+// conn's MaxMessageBytes closes it with CloseMessageTooBig, and Read returns
+// a *CloseError with that code.
+type CompressedWsStream struct {
+	ws *WsStream
+
+	readMu  sync.Mutex
+	inflate io.ReadCloser
+	pending []byte
+
+	writeMu sync.Mutex
+	deflate *flate.Writer
+	buf     bytes.Buffer
+}
+
+// NewCompressedWsStream wraps ws, which must carry nothing else
+func NewCompressedWsStream(ws *WsStream) *CompressedWsStream {
+	return &CompressedWsStream{ws: ws}
+}
+
+// DialCompressed dials uri with d's options, a nil d using the defaults,
+// offering CompressionSubprotocol ahead of d's own subprotocols. If the server
+// selects it the stream is a CompressedWsStream, otherwise a plain WsStream.
+func DialCompressed(ctx context.Context, d *Dialer, uri string) (io.ReadWriteCloser, error) {
+	var opts Dialer
+	if d != nil {
+		opts = *d
+	}
+	opts.Subprotocols = append([]string{CompressionSubprotocol}, opts.Subprotocols...)
+
+	conn, err := opts.Dial(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := NewWsStream(conn)
+	if conn.Subprotocol() != CompressionSubprotocol {
+		return ws, nil
+	}
+	return NewCompressedWsStream(ws), nil
+}
+
+// Read implements io.Reader
+func (cs *CompressedWsStream) Read(p []byte) (int, error) {
+	cs.readMu.Lock()
+	defer cs.readMu.Unlock()
+
+	for len(cs.pending) == 0 {
+		msg, err := cs.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if cs.pending, err = cs.decode(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cs.pending)
+	cs.pending = cs.pending[n:]
+	return n, nil
+}
+
+func (cs *CompressedWsStream) decode(msg []byte) ([]byte, error) {
+	if len(msg) == 0 {
+		return nil, ErrBadCompressedFrame
+	}
+
+	switch msg[0] {
+	case compressRaw:
+		return msg[1:], nil
+	case compressDeflate:
+		src := bytes.NewReader(msg[1:])
+		if cs.inflate == nil {
+			cs.inflate = flate.NewReader(src)
+		} else {
+			cs.inflate.(flate.Resetter).Reset(src, nil)
+		}
+		// a small message may inflate to any size, so hold it to the limit
+		// on inbound messages too
+		var limit int
+		conn, _ := cs.ws.conn.(*Conn)
+		if conn != nil {
+			limit = conn.maxMessage
+		}
+		var r io.Reader = cs.inflate
+		if limit > 0 {
+			r = io.LimitReader(cs.inflate, int64(limit)+1)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, ErrBadCompressedFrame
+		}
+		if limit > 0 && len(data) > limit {
+			err := &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+			conn.CloseWithReason(err.Code, err.Reason)
+			return nil, err
+		}
+		return data, nil
+	}
+	return nil, ErrBadCompressedFrame
+}
+
+// Write implements io.Writer, sending p as one message. Payloads that
+// compression would not shrink are sent raw.
+func (cs *CompressedWsStream) Write(p []byte) (int, error) {
+	cs.writeMu.Lock()
+	defer cs.writeMu.Unlock()
+
+	cs.buf.Reset()
+	cs.buf.WriteByte(compressDeflate)
+
+	compressed := false
+	if len(p) >= compressMinSize {
+		if cs.deflate == nil {
+			cs.deflate, _ = flate.NewWriter(&cs.buf, flate.DefaultCompression)
+		} else {
+			cs.deflate.Reset(&cs.buf)
+		}
+		if _, err := cs.deflate.Write(p); err != nil {
+			return 0, err
+		}
+		if err := cs.deflate.Close(); err != nil {
+			return 0, err
+		}
+		compressed = cs.buf.Len() < 1+len(p)
+	}
+
+	if !compressed {
+		cs.buf.Reset()
+		cs.buf.WriteByte(compressRaw)
+		cs.buf.Write(p)
+	}
+
+	if err := cs.ws.WriteMessage(cs.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying stream
+func (cs *CompressedWsStream) Close() error {
+	return cs.ws.Close()
+}
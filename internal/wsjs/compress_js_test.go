@@ -0,0 +1,86 @@
+package wsjs
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"syscall/js"
+	"testing"
+)
+
+// deliverCompressed makes the fake socket deliver payload as a deflated
+// CompressedWsStream message
+func deliverCompressed(t *testing.T, payload []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	buf.WriteByte(compressDeflate)
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(payload)
+	w.Close()
+
+	array := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(array, buf.Bytes())
+	lastSocket().Call("deliver", array.Get("buffer"))
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	cs := NewCompressedWsStream(NewWsStream(conn))
+
+	payload := bytes.Repeat([]byte("compress me "), 100)
+	if _, err := cs.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(cs, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("payload changed in the round trip")
+	}
+}
+
+func TestCompressedInflateLimit(t *testing.T) {
+	conn := dialTest(t, &Dialer{MaxMessageBytes: 1024}, "sink")
+	cs := NewCompressedWsStream(NewWsStream(conn))
+
+	// well under the limit on the wire, far over it inflated
+	deliverCompressed(t, make([]byte, 1<<20))
+
+	var closeErr *CloseError
+	if _, err := cs.Read(make([]byte, 16)); !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooBig {
+		t.Fatalf("Read returned %v, want a *CloseError with code %d", err, CloseMessageTooBig)
+	}
+	if got := conn.State(); got != StateClosed {
+		t.Fatalf("State = %v, want closed", got)
+	}
+}
+
+func TestDialCompressedKeepsDialerOptions(t *testing.T) {
+	d := &Dialer{Subprotocols: []string{"app"}, MaxMessageBytes: 1024}
+	rwc, err := DialCompressed(testContext(t), d, "ws://sink/")
+	if err != nil {
+		t.Fatalf("DialCompressed: %v", err)
+	}
+	t.Cleanup(func() { rwc.Close() })
+
+	cs, ok := rwc.(*CompressedWsStream)
+	if !ok {
+		t.Fatalf("got %T, want a *CompressedWsStream", rwc)
+	}
+	if got := lastSocket().Get("offered").Length(); got != 2 {
+		t.Fatalf("offered %d subprotocols, want 2", got)
+	}
+	if len(d.Subprotocols) != 1 {
+		t.Fatal("DialCompressed changed the caller's Dialer")
+	}
+
+	// the Dialer's limit applies
+	deliverCompressed(t, make([]byte, 1<<20))
+	var closeErr *CloseError
+	if _, err := cs.Read(make([]byte, 16)); !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooBig {
+		t.Fatalf("Read returned %v, want a *CloseError with code %d", err, CloseMessageTooBig)
+	}
+}
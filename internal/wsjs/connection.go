@@ -0,0 +1,63 @@
+package wsjs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is returned once a Connection has been closed
+var ErrClosed = errors.New("websocket connection closed")
+
+// CloseNormal is the close code (1000) for a normal closure.
+const CloseNormal = 1000
+
+// CloseGoingAway is the close code (1001) an endpoint sends when it goes
+// away, such as a server shutting down or a browser leaving the page.
+const CloseGoingAway = 1001
+
+// Connection is the message-oriented core of a WebSocket connection. *Conn
+// implements it in the browser; code written against it can run on the host
+// too, e.g. over an in-memory pair.
+type Connection interface {
+	NextMessage() ([]byte, error)
+	Send(data []byte) error
+	Close() error
+}
+
+// Message is an inbound WebSocket message
+type Message struct {
+	Data []byte
+	// Text is true for text frames and false for binary ones
+	Text bool
+
+	pooled bool // Data came from getReadBuffer
+}
+
+// CloseError is returned once the connection has closed and carries the close
+// code and reason the browser reported. It matches ErrClosed with errors.Is.
+type CloseError struct {
+	Code     int
+	Reason   string
+	WasClean bool
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s: code %d (%s)", ErrClosed, e.Code, e.Reason)
+	}
+	return fmt.Sprintf("%s: code %d", ErrClosed, e.Code)
+}
+
+func (e *CloseError) Unwrap() error {
+	return ErrClosed
+}
+
+// isNormalClose reports whether err only says the connection is over: closed
+// by the peer with CloseNormal or CloseGoingAway, or closed locally
+func isNormalClose(err error) bool {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code == CloseNormal || closeErr.Code == CloseGoingAway
+	}
+	return errors.Is(err, ErrClosed)
+}
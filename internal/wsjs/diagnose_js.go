@@ -0,0 +1,72 @@
+package wsjs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"syscall/js"
+	"time"
+)
+
+var (
+	_fetch           = js.Global().Get("fetch")
+	_AbortController = js.Global().Get("AbortController")
+)
+
+// diagnoseTimeout bounds the probe, so a server that never answers doesn't
+// hold up reporting the dial failure
+var diagnoseTimeout = 3 * time.Second
+
+// diagnoseDialFailure asks the server for uri over plain HTTP(S) to learn the
+// status code it rejected the upgrade with, since the WebSocket API hides it.
+// It returns a copy of dialErr, the error the dial failed with, whose Probe
+// tells the outcome: the status, or that the fetch failed too (e.g. blocked by
+// CORS) or took longer than diagnoseTimeout. dialErr's Code and Message are
+// kept, so a refused handshake stays apart from a timeout.
+func diagnoseDialFailure(uri string, dialErr *DialError) *DialError {
+	u, err := url.Parse(uri)
+	if err != nil || !_fetch.Truthy() {
+		return dialErr
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	opts := _Object.New()
+	opts.Set("method", "GET")
+	opts.Set("cache", "no-store")
+
+	var abort js.Value
+	if _AbortController.Truthy() {
+		abort = _AbortController.New()
+		opts.Set("signal", abort.Get("signal"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+	defer cancel()
+
+	probed := *dialErr
+	resp, err := awaitContext(ctx, _fetch.Invoke(u.String(), opts))
+	if err != nil {
+		if ctx.Err() != nil {
+			if abort.Truthy() {
+				abort.Call("abort")
+			}
+			probed.Probe = "probe timed out"
+		} else {
+			probed.Probe = "probe failed"
+		}
+		return &probed
+	}
+
+	if status := resp.Get("status").Int(); status != 0 {
+		probed.Probe = fmt.Sprintf("server returned %d", status)
+	} else {
+		probed.Probe = "probe got no status"
+	}
+	return &probed
+}
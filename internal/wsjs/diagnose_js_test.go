@@ -0,0 +1,62 @@
+package wsjs
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+// useFakeFetch replaces fetch for the test with fn
+func useFakeFetch(t *testing.T, fn js.Value) {
+	saved := _fetch
+	_fetch = fn
+	t.Cleanup(func() { _fetch = saved })
+}
+
+func TestDiagnoseStatus(t *testing.T) {
+	useFakeFetch(t, js.Global().Get("Function").New(`return Promise.resolve({ status: 403 });`))
+
+	_, err := (&Dialer{DiagnoseFailure: true}).Dial(testContext(t), "ws://refuse/")
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Probe != "server returned 403" {
+		t.Fatalf("Dial returned %v, want the probed status", err)
+	}
+	if dialErr.Code != 1006 {
+		t.Fatalf("Dial returned code %d, want the original 1006", dialErr.Code)
+	}
+	if !strings.Contains(err.Error(), "server returned 403") {
+		t.Fatalf("error %q doesn't mention the status", err)
+	}
+}
+
+func TestDiagnoseTimeout(t *testing.T) {
+	saved := diagnoseTimeout
+	diagnoseTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { diagnoseTimeout = saved })
+
+	// a server that never answers the probe
+	useFakeFetch(t, js.Global().Get("Function").New(`return new Promise(() => {});`))
+
+	start := time.Now()
+	_, err := (&Dialer{DiagnoseFailure: true}).Dial(testContext(t), "ws://refuse/")
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Code != 1006 || dialErr.Probe != "probe timed out" {
+		t.Fatalf("Dial returned %v, want the original dial error and the timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Dial took %v waiting for the probe", elapsed)
+	}
+}
+
+func TestDiagnoseFetchFails(t *testing.T) {
+	// like a probe blocked by CORS
+	useFakeFetch(t, js.Global().Get("Function").New(`return Promise.reject(new TypeError("Failed to fetch"));`))
+
+	_, err := (&Dialer{DiagnoseFailure: true}).Dial(testContext(t), "ws://refuse/")
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Code != 1006 || dialErr.Probe != "probe failed" {
+		t.Fatalf("Dial returned %v, want the original dial error and the failed probe", err)
+	}
+}
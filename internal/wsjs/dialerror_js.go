@@ -0,0 +1,68 @@
+package wsjs
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+)
+
+// dialCloseGrace is how long a failed dial waits for the close event that
+// follows the error event, since only it carries a close code
+const dialCloseGrace = 50 * time.Millisecond
+
+// DialError is returned when a connection could not be established. Browsers
+// deliberately hide most details of a failed handshake, so Message and Code
+// are often empty; URL always tells which endpoint failed. It matches
+// ErrFailedToDial with errors.Is.
+type DialError struct {
+	URL string
+	// Message is whatever detail the browser reported
+	Message string
+	// Code is the close code reported for the failed connection, if any
+	Code int
+	// Probe is the outcome of the Dialer.DiagnoseFailure probe, such as
+	// "server returned 403" or "probe timed out", if one ran
+	Probe string
+}
+
+func (e *DialError) Error() string {
+	msg := fmt.Sprintf("%s %s", ErrFailedToDial, e.URL)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Code != 0 {
+		msg += fmt.Sprintf(" (code %d)", e.Code)
+	}
+	if e.Probe != "" {
+		msg += "; " + e.Probe
+	}
+	return msg
+}
+
+func (e *DialError) Unwrap() error {
+	return ErrFailedToDial
+}
+
+// dialErrorFromEvent builds a DialError from a WebSocket error event
+func dialErrorFromEvent(uri string, args []js.Value) *DialError {
+	err := &DialError{URL: uri}
+	if len(args) > 0 && args[0].Get("message").Type() == js.TypeString {
+		err.Message = args[0].Get("message").String()
+	}
+	return err
+}
+
+// addCloseDetail fills in the close code and reason once the close event
+// following a failed dial arrives, waiting at most dialCloseGrace for it
+func (conn *Conn) addCloseDetail(err *DialError) {
+	select {
+	case <-conn.closeChan:
+	case <-time.After(dialCloseGrace):
+		return
+	}
+
+	err.Code = conn.closeCode
+	if err.Message == "" {
+		err.Message = conn.closeReason
+	}
+}
@@ -0,0 +1,76 @@
+package wsjs
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+// DualWriter mirrors every outbound frame to a secondary connection while
+// reads are served by the primary only. It is meant for validating a new
+// backend with live traffic before cutting over to it.
+type DualWriter struct {
+	// FailOnSecondaryError makes Send return errors from the secondary
+	// connection instead of only logging them.
+	FailOnSecondaryError bool
+
+	primary   *Conn
+	secondary *Conn
+}
+
+// NewDualWriter creates a DualWriter over primary and secondary.
+// Inbound frames on the secondary are drained and discarded.
+func NewDualWriter(primary, secondary *Conn) *DualWriter {
+	d := &DualWriter{
+		primary:   primary,
+		secondary: secondary,
+	}
+
+	// Keep the secondary's receive queue from filling up
+	go func() {
+		for {
+			if _, err := secondary.NextMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return d
+}
+
+// Send sends data to the primary and then to the secondary connection.
+func (d *DualWriter) Send(data []byte) error {
+	if err := d.primary.Send(data); err != nil {
+		return err
+	}
+
+	if err := d.secondary.Send(data); err != nil {
+		if d.FailOnSecondaryError {
+			return err
+		}
+		log.Warn().Err(err).Msg("[DualWriter] Secondary send failed")
+	}
+
+	return nil
+}
+
+// Write implements io.Writer interface, sending p as one frame to both connections
+func (d *DualWriter) Write(p []byte) (n int, err error) {
+	if err := d.Send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NextMessage returns the next message from the primary connection
+func (d *DualWriter) NextMessage() ([]byte, error) {
+	return d.primary.NextMessage()
+}
+
+// Close closes both connections, returning the primary's error first
+func (d *DualWriter) Close() error {
+	errPrimary := d.primary.Close()
+	errSecondary := d.secondary.Close()
+	if errPrimary != nil {
+		return errPrimary
+	}
+	return errSecondary
+}
@@ -0,0 +1,128 @@
+package wsjs
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// eventLogQueue is how many events may wait for the writer before new ones
+// are dropped
+const eventLogQueue = 256
+
+// logEvent is one line of the JSON event log. It never carries payloads.
+type logEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // "connect", "send", "recv" or "close"
+	URL    string    `json:"url,omitempty"`
+	Type   string    `json:"type,omitempty"` // "text" or "binary"
+	Size   int       `json:"size,omitempty"`
+	Code   int       `json:"code,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type eventLog struct {
+	events chan logEvent
+}
+
+func newEventLog(w io.Writer) *eventLog {
+	l := &eventLog{
+		events: make(chan logEvent, eventLogQueue),
+	}
+
+	go func() {
+		enc := json.NewEncoder(w)
+		for ev := range l.events {
+			enc.Encode(ev)
+		}
+	}()
+
+	return l
+}
+
+// emit queues ev without blocking; events are dropped if the writer lags.
+// ev is stamped with the current time unless it already has one.
+func (l *eventLog) emit(ev logEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case l.events <- ev:
+	default:
+	}
+}
+
+func (l *eventLog) stop() {
+	close(l.events)
+}
+
+// SetEventLog writes a JSON object per line to w for every connection event:
+// "connect" when the connection opens, then "send" and "recv" with frame type
+// and size, and "close" with the close code. A log attached after the open
+// still starts with "connect", stamped with the time the connection opened.
+// Payloads are never logged, so it is safe to leave on in production. Writes
+// happen on a separate goroutine and events are dropped rather than stalling
+// the event loop if w is slow. Passing nil turns the log off.
+func (conn *Conn) SetEventLog(w io.Writer) {
+	var l *eventLog
+	if w != nil {
+		l = newEventLog(w)
+	}
+
+	conn.eventMu.Lock()
+	prev := conn.events
+	conn.events = l
+	if l != nil && !conn.openedAt.IsZero() {
+		l.emit(conn.connectEvent())
+	}
+	conn.eventMu.Unlock()
+
+	if prev != nil {
+		prev.stop()
+	}
+}
+
+// logOpen records the open time and logs "connect" to a log attached while
+// connecting
+func (conn *Conn) logOpen() {
+	conn.eventMu.Lock()
+	defer conn.eventMu.Unlock()
+
+	conn.openedAt = time.Now()
+	if conn.events != nil {
+		conn.events.emit(conn.connectEvent())
+	}
+}
+
+func (conn *Conn) connectEvent() logEvent {
+	return logEvent{Time: conn.openedAt, Event: "connect", URL: conn.url}
+}
+
+func (conn *Conn) logEvent(ev logEvent) {
+	conn.eventMu.Lock()
+	defer conn.eventMu.Unlock()
+
+	if conn.events != nil {
+		conn.events.emit(ev)
+	}
+}
+
+// closeEventLog logs the close event and stops the log
+func (conn *Conn) closeEventLog(code int, reason string) {
+	conn.eventMu.Lock()
+	l := conn.events
+	conn.events = nil
+	conn.eventMu.Unlock()
+
+	if l != nil {
+		l.emit(logEvent{Event: "close", Code: code, Reason: reason})
+		l.stop()
+	}
+}
+
+func frameTypeName(isText bool) string {
+	if isText {
+		return "text"
+	}
+	return "binary"
+}
@@ -0,0 +1,79 @@
+package wsjs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// eventWriter decodes the event log, one JSON object per Write
+type eventWriter chan logEvent
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	var ev logEvent
+	if err := json.Unmarshal(p, &ev); err != nil {
+		return 0, err
+	}
+	w <- ev
+	return len(p), nil
+}
+
+func (w eventWriter) next(t *testing.T) logEvent {
+	t.Helper()
+
+	select {
+	case ev := <-w:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event logged")
+		return logEvent{}
+	}
+}
+
+func TestEventLogConnectAtOpen(t *testing.T) {
+	conn, err := NewConn("ws://hang/")
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	defer conn.Close()
+
+	events := make(eventWriter, 8)
+	conn.SetEventLog(events)
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case ev := <-events:
+		t.Fatalf("logged %+v before the connection opened", ev)
+	default:
+	}
+
+	openedAfter := time.Now()
+	lastSocket().Call("_open")
+	if err := conn.WaitOpen(testContext(t)); err != nil {
+		t.Fatalf("WaitOpen: %v", err)
+	}
+	ev := events.next(t)
+	if ev.Event != "connect" || ev.URL != "ws://hang/" {
+		t.Fatalf("first event %+v, want connect", ev)
+	}
+	if ev.Time.Before(openedAfter) {
+		t.Fatalf("connect stamped %v, before the open at %v", ev.Time, openedAfter)
+	}
+}
+
+func TestEventLogAttachedLate(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	openedBy := time.Now()
+	time.Sleep(20 * time.Millisecond)
+
+	events := make(eventWriter, 8)
+	conn.SetEventLog(events)
+	conn.Send([]byte("hi"))
+
+	ev := events.next(t)
+	if ev.Event != "connect" || ev.Time.After(openedBy) {
+		t.Fatalf("first event %+v, want connect stamped by %v", ev, openedBy)
+	}
+	if ev := events.next(t); ev.Event != "send" || ev.Size != 2 {
+		t.Fatalf("second event %+v, want the send", ev)
+	}
+}
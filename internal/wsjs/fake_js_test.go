@@ -0,0 +1,256 @@
+package wsjs
+
+import (
+	"context"
+	"os"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+// fakeWebSocketJS is a stand-in for the browser's WebSocket, so the package
+// can be tested under Node, which has none:
+//
+//	GOOS=js GOARCH=wasm go test -exec="$(go env GOROOT)/lib/wasm/go_js_wasm_exec" ./internal/wsjs
+//
+// The URL's host picks how it behaves:
+//
+//	echo           opens, and sends every frame back
+//	sink           opens, and keeps what is sent without answering
+//	discard        opens, and drops what is sent
+//	close-on-open  closes with 1001 as it opens, before Go sees the open event
+//	refuse         fails the handshake
+//	hang           never opens, unless the test calls _open()
+//
+// Opened sockets select the first subprotocol offered, if any, and report the
+// extensions named by the URL's extensions parameter. Sockets are
+// recorded in FakeWebSocket.sockets, newest last. Tests set
+// bufferedAmount directly to simulate a stalled link.
+const fakeWebSocketJS = `
+class FakeWebSocket extends EventTarget {
+	constructor(url, protocols) {
+		super();
+		this.url = url;
+		this.readyState = 0;
+		this.bufferedAmount = 0;
+		this.protocol = "";
+		this.extensions = "";
+		this.binaryType = "blob";
+		this.sent = [];
+		this.offered = protocols === undefined ? [] : [].concat(protocols);
+		this.mode = new URL(url).host;
+		FakeWebSocket.sockets.push(this);
+
+		if (this.mode === "close-on-open") {
+			// runs before the listeners Go adds after construction
+			this.addEventListener("open", () => this._closed(1001, "going away", true));
+		}
+
+		switch (this.mode) {
+		case "hang":
+			return;
+		case "refuse":
+			setTimeout(() => {
+				this.readyState = 2;
+				this.dispatchEvent(new Event("error"));
+				this._closed(1006, "", false);
+			}, 0);
+			return;
+		}
+		setTimeout(() => this._open(), 0);
+	}
+
+	_open() {
+		if (this.readyState !== 0) {
+			return;
+		}
+		this.readyState = 1;
+		// agree to the first subprotocol offered, like a willing server
+		this.protocol = this.offered.length > 0 ? this.offered[0] : "";
+		this.extensions = new URL(this.url).searchParams.get("extensions") || "";
+		this.dispatchEvent(new Event("open"));
+	}
+
+	send(data) {
+		if (this.readyState !== 1) {
+			throw new Error("InvalidStateError: not open");
+		}
+		if (this.mode === "discard") {
+			return;
+		}
+		let copy = data;
+		if (typeof data !== "string") {
+			const view = ArrayBuffer.isView(data) ? data : new Uint8Array(data);
+			copy = view.buffer.slice(view.byteOffset, view.byteOffset + view.byteLength);
+		}
+		this.sent.push(copy);
+		if (this.mode === "echo") {
+			setTimeout(() => this.deliver(copy), 0);
+		}
+	}
+
+	deliver(data) {
+		if (this.readyState !== 1) {
+			return;
+		}
+		const ev = new Event("message");
+		ev.data = data;
+		this.dispatchEvent(ev);
+	}
+
+	close(code, reason) {
+		if (this.readyState >= 2) {
+			return;
+		}
+		const connecting = this.readyState === 0;
+		this.readyState = 2;
+		setTimeout(() => {
+			if (connecting) {
+				this.dispatchEvent(new Event("error"));
+			}
+			this._closed(code === undefined ? 1005 : code, reason || "", !connecting);
+		}, 0);
+	}
+
+	_closed(code, reason, wasClean) {
+		if (this.readyState === 3) {
+			return;
+		}
+		this.readyState = 3;
+		const ev = new Event("close");
+		ev.code = code;
+		ev.reason = reason;
+		ev.wasClean = wasClean;
+		this.dispatchEvent(ev);
+	}
+}
+FakeWebSocket.sockets = [];
+return FakeWebSocket;
+`
+
+// fakeWebSocketStreamJS is the WebSocketStream counterpart of
+// fakeWebSocketJS. It always opens; deliver(data) queues a message on the
+// readable side, whose controller's desiredSize shows how far it was read.
+// What is written is kept in written.
+const fakeWebSocketStreamJS = `
+class FakeWebSocketStream {
+	constructor(url, options) {
+		this.url = url;
+		this.written = [];
+		FakeWebSocketStream.streams.push(this);
+
+		const readable = new ReadableStream({
+			start: (controller) => { this.readController = controller; },
+		}, { highWaterMark: 0 });
+		const writable = new WritableStream({
+			write: (chunk) => { this.written.push(chunk); },
+		});
+		this.opened = Promise.resolve({ readable, writable, protocol: "", extensions: "" });
+		this.closed = new Promise((resolve) => { this._resolveClosed = resolve; });
+	}
+
+	deliver(data) {
+		this.readController.enqueue(data);
+	}
+
+	close(info) {
+		info = info || {};
+		try {
+			this.readController.close();
+		} catch (e) {
+			// already closed
+		}
+		this._resolveClosed({ closeCode: info.closeCode || 1005, reason: info.reason || "" });
+	}
+}
+FakeWebSocketStream.streams = [];
+return FakeWebSocketStream;
+`
+
+var fakeWebSocket js.Value
+
+func TestMain(m *testing.M) {
+	fakeWebSocket = js.Global().Get("Function").New(fakeWebSocketJS).Invoke()
+	_WebSocket = fakeWebSocket
+	unsupportedReason = checkSupport()
+
+	os.Exit(m.Run())
+}
+
+// useFakeWebSocketStream makes dials in the test go over a fake
+// WebSocketStream
+func useFakeWebSocketStream(t *testing.T) {
+	saved := _WebSocketStream
+	_WebSocketStream = js.Global().Get("Function").New(fakeWebSocketStreamJS).Invoke()
+	t.Cleanup(func() { _WebSocketStream = saved })
+}
+
+// lastStream returns the fake stream created most recently
+func lastStream() js.Value {
+	streams := _WebSocketStream.Get("streams")
+	return streams.Index(streams.Length() - 1)
+}
+
+// lastSocket returns the fake socket created most recently
+func lastSocket() js.Value {
+	sockets := fakeWebSocket.Get("sockets")
+	return sockets.Index(sockets.Length() - 1)
+}
+
+// dialTest dials the fake socket behaving as mode and closes it at the end
+func dialTest(t *testing.T, d *Dialer, mode string) *Conn {
+	t.Helper()
+
+	if d == nil {
+		d = &Dialer{}
+	}
+	conn, err := d.Dial(testContext(t), "ws://"+mode+"/")
+	if err != nil {
+		t.Fatalf("Dial %s: %v", mode, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sentFrames returns what was sent on the newest fake socket, binary frames
+// as their bytes
+func sentFrames() []string {
+	sent := lastSocket().Get("sent")
+	frames := make([]string, sent.Length())
+	for i := range frames {
+		frame := sent.Index(i)
+		if frame.Type() == js.TypeString {
+			frames[i] = frame.String()
+			continue
+		}
+		data := make([]byte, frame.Get("byteLength").Int())
+		js.CopyBytesToGo(data, js.Global().Get("Uint8Array").New(frame))
+		frames[i] = string(data)
+	}
+	return frames
+}
+
+// nextMessage is NextMessage failing the test if nothing arrives in time
+func nextMessage(t *testing.T, conn *Conn) []byte {
+	t.Helper()
+
+	ctx := testContext(t)
+	data, err := conn.NextMessageContext(ctx)
+	if err != nil {
+		t.Fatalf("NextMessage: %v", err)
+	}
+	return data
+}
+
+// testContext returns a context that gives up well before the test timeout
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// waitTick yields to the JS event loop for a moment, letting fake sockets
+// deliver queued events
+func waitTick() {
+	time.Sleep(time.Millisecond)
+}
@@ -0,0 +1,202 @@
+package wsjs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNoKeepalive = errors.New("websocket keepalive not started")
+
+// DefaultKeepalivePayload is the ping StartKeepalive sends when given an empty
+// payload. Pings can't be empty: any empty frame would then count as a pong,
+// and with EmptyFrameSkip or EmptyFrameError they would never go out.
+var DefaultKeepalivePayload = []byte("\x00ping")
+
+// StartKeepalive sends payload every interval as an application-level ping,
+// since browsers cannot send WebSocket ping frames, and keeps idle tunnels
+// from being dropped by intermediaries.
+//
+// The peer is expected to echo payload back: an inbound frame equal to
+// payload counts as a pong, goes to the pong handler and is not queued for
+// NextMessage. An empty payload uses DefaultKeepalivePayload. If a ping is
+// not answered within 2*interval the connection closes itself and
+// NextMessage returns ErrClosed.
+//
+// The keepalive runs on its own goroutine, started here and stopped on Close.
+// Calling StartKeepalive again replaces the previous keepalive, and a
+// non-positive interval stops it without starting a new one.
+func (conn *Conn) StartKeepalive(interval time.Duration, payload []byte) {
+	if interval <= 0 {
+		conn.stopKeepalive()
+		return
+	}
+
+	if len(payload) == 0 {
+		payload = DefaultKeepalivePayload
+	}
+	stop := make(chan struct{})
+	pongs := make(chan struct{}, 1)
+	payload = append([]byte(nil), payload...)
+
+	conn.keepaliveMu.Lock()
+	if conn.keepaliveStop != nil {
+		close(conn.keepaliveStop)
+	}
+	conn.keepaliveStop = stop
+	conn.keepalivePong = pongs
+	conn.keepalivePayload = payload
+	conn.keepaliveMu.Unlock()
+
+	go conn.keepalive(interval, payload, stop, pongs)
+}
+
+// SetPongHandler sets fn to be called with every pong received in reply to
+// keepalive pings
+func (conn *Conn) SetPongHandler(fn func([]byte)) {
+	conn.keepaliveMu.Lock()
+	conn.pongHandler = fn
+	conn.keepaliveMu.Unlock()
+}
+
+// keepalive pings every interval. The first ping left unanswered arms a
+// deadline of 2*interval, which a pong disarms.
+func (conn *Conn) keepalive(interval time.Duration, payload []byte, stop, pongs chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var deadline *time.Timer
+	var timeout <-chan time.Time
+	var sent time.Time // when the last ping went out
+	defer func() {
+		if deadline != nil {
+			deadline.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.SendPriority(payload); err != nil {
+				return
+			}
+			sent = time.Now()
+			if deadline == nil {
+				deadline = time.NewTimer(2 * interval)
+				timeout = deadline.C
+			}
+		case <-pongs:
+			if deadline != nil {
+				deadline.Stop()
+				deadline, timeout = nil, nil
+
+				conn.keepaliveMu.Lock()
+				quality := conn.quality
+				conn.keepaliveMu.Unlock()
+				if quality != nil {
+					quality.ObserveRTT(time.Since(sent))
+				}
+			}
+		case <-timeout:
+			conn.CloseWithReason(CloseNormal, "keepalive timeout")
+			return
+		case <-stop:
+			return
+		case <-conn.closeChan:
+			return
+		}
+	}
+}
+
+// handlePong reports whether data is a pong, recording it if so
+func (conn *Conn) handlePong(data []byte) bool {
+	conn.keepaliveMu.Lock()
+	if conn.keepaliveStop == nil || !bytes.Equal(data, conn.keepalivePayload) {
+		conn.keepaliveMu.Unlock()
+		return false
+	}
+	select {
+	case conn.keepalivePong <- struct{}{}:
+	default:
+	}
+	fn := conn.pongHandler
+	for _, ch := range conn.pingWaiters {
+		close(ch)
+	}
+	conn.pingWaiters = nil
+	conn.keepaliveMu.Unlock()
+
+	if fn != nil {
+		fn(data)
+	}
+	return true
+}
+
+// Ping sends a keepalive ping right away and waits for the pong, to check that
+// the peer is still there. It fails with ErrNoKeepalive unless a keepalive
+// was started, since the peer only echoes the keepalive payload, with
+// ErrClosed once the connection closes, and with ctx.Err() once ctx is done.
+// A pong to any ping, including the periodic ones, answers it. The round
+// trip is reported to the estimator set with SetQualityEstimator, if any.
+func (conn *Conn) Ping(ctx context.Context) error {
+	conn.keepaliveMu.Lock()
+	if conn.keepaliveStop == nil {
+		conn.keepaliveMu.Unlock()
+		return ErrNoKeepalive
+	}
+	payload := conn.keepalivePayload
+	quality := conn.quality
+	pong := make(chan struct{})
+	conn.pingWaiters = append(conn.pingWaiters, pong)
+	conn.keepaliveMu.Unlock()
+
+	defer conn.removePingWaiter(pong)
+
+	start := time.Now()
+	if err := conn.SendPriority(payload); err != nil {
+		return err
+	}
+
+	select {
+	case <-pong:
+		if quality != nil {
+			quality.ObserveRTT(time.Since(start))
+		}
+		return nil
+	case <-conn.closeChan:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetQualityEstimator makes Ping and the periodic keepalive pings report
+// every round trip to q. A nil q stops reporting.
+func (conn *Conn) SetQualityEstimator(q *QualityEstimator) {
+	conn.keepaliveMu.Lock()
+	conn.quality = q
+	conn.keepaliveMu.Unlock()
+}
+
+func (conn *Conn) removePingWaiter(pong chan struct{}) {
+	conn.keepaliveMu.Lock()
+	defer conn.keepaliveMu.Unlock()
+
+	for i, ch := range conn.pingWaiters {
+		if ch == pong {
+			conn.pingWaiters = append(conn.pingWaiters[:i], conn.pingWaiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// stopKeepalive stops the keepalive goroutine, if any
+func (conn *Conn) stopKeepalive() {
+	conn.keepaliveMu.Lock()
+	if conn.keepaliveStop != nil {
+		close(conn.keepaliveStop)
+		conn.keepaliveStop = nil
+	}
+	conn.keepaliveMu.Unlock()
+}
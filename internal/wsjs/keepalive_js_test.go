@@ -0,0 +1,133 @@
+package wsjs
+
+import (
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+func TestKeepalivePing(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	conn.StartKeepalive(time.Hour, []byte("ping"))
+
+	if err := conn.Ping(testContext(t)); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestStartKeepaliveNonPositiveInterval(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+
+	conn.StartKeepalive(0, []byte("ping"))
+	if err := conn.Ping(testContext(t)); !errors.Is(err, ErrNoKeepalive) {
+		t.Fatalf("Ping returned %v, want ErrNoKeepalive", err)
+	}
+
+	// stops a running keepalive
+	conn.StartKeepalive(time.Hour, []byte("ping"))
+	conn.StartKeepalive(-time.Second, []byte("ping"))
+	if err := conn.Ping(testContext(t)); !errors.Is(err, ErrNoKeepalive) {
+		t.Fatalf("Ping after stopping returned %v, want ErrNoKeepalive", err)
+	}
+}
+
+func TestPingFeedsQualityEstimator(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	conn.StartKeepalive(time.Hour, []byte("ping"))
+	q := NewQualityEstimator(0)
+	conn.SetQualityEstimator(q)
+
+	for i := 0; i < 2; i++ {
+		if err := conn.Ping(testContext(t)); err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.rtts) != 2 {
+		t.Fatalf("estimator has %d RTT samples, want 2", len(q.rtts))
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	// sink never answers the pings
+	conn := dialTest(t, nil, "sink")
+	interval := 20 * time.Millisecond
+	start := time.Now()
+	conn.StartKeepalive(interval, []byte("ping"))
+
+	select {
+	case <-conn.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection still open with every ping unanswered")
+	}
+	// the first ping goes out after one interval, the deadline two later
+	if elapsed := time.Since(start); elapsed > 3*interval+50*time.Millisecond {
+		t.Fatalf("timed out after %v, want about %v", elapsed, 3*interval)
+	}
+	if _, reason, _ := conn.CloseCode(); reason != "keepalive timeout" {
+		t.Fatalf("closed with reason %q, want the keepalive timeout", reason)
+	}
+}
+
+func TestKeepaliveAnsweredStaysOpen(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	conn.StartKeepalive(10*time.Millisecond, []byte("ping"))
+
+	time.Sleep(100 * time.Millisecond)
+	if got := conn.State(); got != StateOpen {
+		t.Fatalf("State = %v with every ping answered, want open", got)
+	}
+}
+
+func TestKeepaliveDefaultPayload(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+	conn.StartKeepalive(time.Hour, nil)
+
+	// an empty data frame is not a pong to an empty ping
+	lastSocket().Call("deliver", js.Global().Get("ArrayBuffer").New(0))
+	if got := nextMessage(t, conn); len(got) != 0 {
+		t.Fatalf("got %q, want the empty frame", got)
+	}
+}
+
+func TestKeepaliveEveryEmptyFramePolicy(t *testing.T) {
+	sendErr := map[EmptyFramePolicy]error{
+		EmptyFrameSend:  nil,
+		EmptyFrameError: ErrEmptyFrame,
+		EmptyFrameSkip:  nil,
+	}
+	for policy, want := range sendErr {
+		conn := dialTest(t, &Dialer{EmptyFrames: policy, KeepaliveInterval: time.Hour}, "echo")
+
+		if err := conn.Send(nil); err != want {
+			t.Fatalf("policy %d: Send(nil) returned %v, want %v", policy, err, want)
+		}
+		if err := conn.Ping(testContext(t)); err != nil {
+			t.Fatalf("policy %d: Ping: %v", policy, err)
+		}
+	}
+}
+
+func TestKeepaliveFeedsQualityEstimator(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	q := NewQualityEstimator(0)
+	conn.SetQualityEstimator(q)
+	conn.StartKeepalive(10*time.Millisecond, []byte("ping"))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		n := len(q.rtts)
+		q.mu.Unlock()
+		if n >= 2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("estimator has %d RTT samples from the keepalive, want some", n)
+		}
+		waitTick()
+	}
+}
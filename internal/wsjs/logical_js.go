@@ -0,0 +1,185 @@
+package wsjs
+
+import (
+	"errors"
+)
+
+// Logical messages let a payload of any size travel as a run of frames that
+// the receiver puts back together. Every frame starts with one header byte
+// followed by a slice of the payload:
+//
+//	bit 0 (0x01)  first frame of a message
+//	bit 1 (0x02)  last frame of a message
+//
+// A message that fits in one frame has header 0x03; a longer one is sent as
+// 0x01, zero or more 0x00, then 0x02. The other bits are reserved and must be
+// zero. Both ends must use logical messages for the whole connection.
+const (
+	logicalFirst byte = 1 << 0
+	logicalLast  byte = 1 << 1
+)
+
+// DefaultLogicalFrameSize is the payload carried per frame by
+// SendLogicalMessage when no size is given.
+const DefaultLogicalFrameSize = 64 * 1024
+
+var ErrBadLogicalFrame = errors.New("websocket malformed logical message frame")
+
+// SendLogicalMessage sends data as one logical message, split into frames
+// carrying at most frameSize payload bytes each (DefaultLogicalFrameSize if
+// frameSize is not positive). The frames go out back to back, without other
+// sends interleaving, and bypass write coalescing; pending coalesced data is
+// flushed first, so they still follow what was written before.
+func (conn *Conn) SendLogicalMessage(data []byte, frameSize int) error {
+	if frameSize <= 0 {
+		frameSize = DefaultLogicalFrameSize
+	}
+
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	if err := conn.flushLocked(); err != nil {
+		return err
+	}
+
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	return conn.writeLogicalLocked(data, frameSize)
+}
+
+// SetMaxFrameBytes makes every binary send (Send, SendPriority, SendReader,
+// coalesced writes and so WsStream.Write) go out as a logical message of
+// frames carrying at most n payload bytes each, for intermediaries that
+// choke on very large frames. Inbound binary frames are then expected in the
+// same format and put back together before they are queued, so NextMessage
+// and WsStream.ReadMessage return whole messages; MaxMessageBytes applies to
+// the reassembled size. Text frames are neither split nor expected to carry
+// a header.
+//
+// The wire format is the one of SendLogicalMessage: each binary frame starts
+// with a header byte, 0x01 on the first frame of a message and 0x02 on the
+// last (0x03 for a message that fits in one frame, 0x00 in between), followed
+// by up to n bytes of the payload. The peer must use it for the whole
+// connection, so set this right after dialing, before any frame is
+// exchanged. A malformed frame closes the connection with
+// ClosePolicyViolation and NextMessage returns ErrBadLogicalFrame. Zero, the
+// default, sends every payload as a single plain frame.
+//
+// Being the same format, it is the receiving half of SendLogicalMessage done
+// for every frame: messages from SendLogicalMessage arrive whole through
+// NextMessage, and NextLogicalMessage becomes the same as NextMessage.
+func (conn *Conn) SetMaxFrameBytes(n int) {
+	conn.maxFrame.Store(int64(max(n, 0)))
+}
+
+func logicalHeader(first, last bool) byte {
+	var header byte
+	if first {
+		header |= logicalFirst
+	}
+	if last {
+		header |= logicalLast
+	}
+	return header
+}
+
+// writeLogicalLocked sends data as a logical message of frames of at most
+// frameSize payload bytes. sendMu must be held.
+func (conn *Conn) writeLogicalLocked(data []byte, frameSize int) error {
+	for first := true; ; first = false {
+		chunk := data
+		if len(chunk) > frameSize {
+			chunk = chunk[:frameSize]
+		}
+		data = data[len(chunk):]
+
+		frame := make([]byte, 1+len(chunk))
+		frame[0] = logicalHeader(first, len(data) == 0)
+		copy(frame[1:], chunk)
+		if err := conn.writeFrameLocked(frame); err != nil {
+			return err
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// reassemble adds an inbound binary frame to the logical message in progress
+// and returns the message once its last frame is in. ok is false while more
+// frames are due or if the frame was rejected. It must be called from the
+// receive path; the returned data is never pooled.
+func (conn *Conn) reassemble(frame []byte, pooled bool) (data []byte, ok bool) {
+	if pooled {
+		defer putReadBuffer(frame)
+	}
+
+	if len(frame) == 0 || frame[0]&^(logicalFirst|logicalLast) != 0 ||
+		(frame[0]&logicalFirst != 0) == conn.partialStarted {
+		conn.partial, conn.partialStarted = nil, false
+		conn.failProtocol(ErrBadLogicalFrame, ClosePolicyViolation, "malformed frame")
+		return nil, false
+	}
+	header, payload := frame[0], frame[1:]
+
+	if conn.tooBig(len(conn.partial) + len(payload)) {
+		conn.partial, conn.partialStarted = nil, false
+		return nil, false
+	}
+
+	if header&logicalLast != 0 && !conn.partialStarted {
+		if pooled {
+			payload = append([]byte(nil), payload...)
+		}
+		return payload, true
+	}
+
+	conn.partial = append(conn.partial, payload...)
+	conn.partialStarted = true
+	if header&logicalLast == 0 {
+		return nil, false
+	}
+
+	data = conn.partial
+	conn.partial, conn.partialStarted = nil, false
+	return data, true
+}
+
+// NextLogicalMessage reads frames until a whole logical message has arrived
+// and returns its reassembled payload. With SetMaxFrameBytes in effect the
+// receive path already reassembles messages, so it is just NextMessage.
+func (conn *Conn) NextLogicalMessage() ([]byte, error) {
+	if conn.maxFrame.Load() > 0 {
+		return conn.NextMessage()
+	}
+
+	var message []byte
+	started := false
+
+	for {
+		frame, err := conn.NextMessage()
+		if err != nil {
+			return nil, err
+		}
+		if len(frame) == 0 || frame[0]&^(logicalFirst|logicalLast) != 0 {
+			return nil, ErrBadLogicalFrame
+		}
+
+		header := frame[0]
+		if (header&logicalFirst != 0) == started {
+			// a continuation without a start, or a new start mid-message
+			return nil, ErrBadLogicalFrame
+		}
+		started = true
+
+		if header&logicalLast != 0 && message == nil {
+			return frame[1:], nil
+		}
+		message = append(message, frame[1:]...)
+		if header&logicalLast != 0 {
+			return message, nil
+		}
+	}
+}
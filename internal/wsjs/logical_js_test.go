@@ -0,0 +1,64 @@
+package wsjs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogicalMessageRoundTrip(t *testing.T) {
+	for _, maxFrame := range []int{0, 5} {
+		conn := dialTest(t, nil, "echo")
+		conn.SetMaxFrameBytes(maxFrame)
+
+		data := []byte("split across several frames")
+		if err := conn.SendLogicalMessage(data, 4); err != nil {
+			t.Fatalf("SendLogicalMessage: %v", err)
+		}
+		got, err := conn.NextLogicalMessage()
+		if err != nil {
+			t.Fatalf("max frame %d: NextLogicalMessage: %v", maxFrame, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("max frame %d: got %q, want %q", maxFrame, got, data)
+		}
+	}
+}
+
+func TestNextLogicalMessageWithMaxFrame(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+	conn.SetMaxFrameBytes(4)
+
+	// reassembled on receipt, so NextLogicalMessage must not look for a
+	// header in the payload
+	for _, data := range []string{"\x03abc", "longer than one frame"} {
+		if err := conn.Send([]byte(data)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		got, err := conn.NextLogicalMessage()
+		if err != nil {
+			t.Fatalf("NextLogicalMessage: %v", err)
+		}
+		if string(got) != data {
+			t.Fatalf("got %q, want %q", got, data)
+		}
+	}
+}
+
+func TestSendLogicalMessageFlushesCoalesced(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+	conn.SetWriteCoalesceDelay(time.Hour)
+
+	if err := conn.Send([]byte("first")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := conn.SendLogicalMessage([]byte("then"), 2); err != nil {
+		t.Fatalf("SendLogicalMessage: %v", err)
+	}
+
+	want := []string{"first", "\x01th", "\x02en"}
+	if got := sentFrames(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+}
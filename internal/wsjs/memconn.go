@@ -0,0 +1,104 @@
+//go:build !js
+
+package wsjs
+
+import (
+	"sync"
+)
+
+// memConn is an in-memory Connection for exercising message-level logic
+// outside the browser. Messages are delivered in order to the other end of
+// the pair created by newMemConnPair. Like *Conn it also carries text frames,
+// through SendText and NextFrame.
+type memConn struct {
+	in   chan Message
+	peer *memConn
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+var _ Connection = (*memConn)(nil)
+
+// newMemConnPair returns two connected ends, each buffering up to buffer
+// messages sent by the other
+func newMemConnPair(buffer int) (*memConn, *memConn) {
+	a := &memConn{in: make(chan Message, buffer), closeChan: make(chan struct{})}
+	b := &memConn{in: make(chan Message, buffer), closeChan: make(chan struct{})}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// NextMessage returns the next message sent by the peer. Messages queued
+// before a close are still delivered.
+func (c *memConn) NextMessage() ([]byte, error) {
+	msg, err := c.next()
+	return msg.Data, err
+}
+
+// NextFrame is like NextMessage but also reports whether the message was
+// sent as text
+func (c *memConn) NextFrame() ([]byte, bool, error) {
+	msg, err := c.next()
+	return msg.Data, msg.Text, err
+}
+
+func (c *memConn) next() (Message, error) {
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	default:
+	}
+
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	case <-c.closeChan:
+		return Message{}, ErrClosed
+	case <-c.peer.closeChan:
+		select {
+		case msg := <-c.in:
+			return msg, nil
+		default:
+			return Message{}, ErrClosed
+		}
+	}
+}
+
+// Send copies data and queues it for the peer, blocking while its buffer is
+// full
+func (c *memConn) Send(data []byte) error {
+	return c.send(Message{Data: append([]byte(nil), data...)})
+}
+
+// SendText queues s for the peer as a text message
+func (c *memConn) SendText(s string) error {
+	return c.send(Message{Data: []byte(s), Text: true})
+}
+
+func (c *memConn) send(msg Message) error {
+	select {
+	case <-c.closeChan:
+		return ErrClosed
+	case <-c.peer.closeChan:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.peer.in <- msg:
+		return nil
+	case <-c.closeChan:
+		return ErrClosed
+	case <-c.peer.closeChan:
+		return ErrClosed
+	}
+}
+
+// Close closes this end; both ends then fail with ErrClosed
+func (c *memConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+	return nil
+}
@@ -0,0 +1,354 @@
+package wsjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// A Session multiplexes streams over one Connection. Every WebSocket frame
+// carries one mux frame, a 9 byte header followed by the payload:
+//
+//	byte  0     type: 0 data, 1 SYN (open a stream), 2 FIN (no more data)
+//	bytes 1-4   stream id, big endian
+//	bytes 5-8   payload length, big endian; must match the rest of the frame
+//
+// SYN and FIN carry no payload. Streams opened by this end (the browser) use
+// odd ids and streams opened by the peer use even ids, so both can open
+// streams without coordinating. A stream is done once both ends sent FIN.
+// There is no flow control: data for a stream is buffered until read.
+const (
+	muxData byte = iota
+	muxSYN
+	muxFIN
+)
+
+const (
+	muxHeaderSize = 9
+
+	// muxMaxPayload is the most data a single data frame carries
+	muxMaxPayload = 32 * 1024
+
+	// muxAcceptBacklog is how many peer-opened streams may wait to be
+	// accepted before further ones are refused
+	muxAcceptBacklog = 16
+)
+
+var ErrBadMuxFrame = errors.New("websocket malformed mux frame")
+
+// Session is a multiplexer over a Connection, usually a *Conn. All reads of
+// the Connection must go through the Session once it is created.
+type Session struct {
+	conn Connection
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error // set once the session is closed
+
+	incoming  chan *Stream
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSession starts multiplexing streams over conn
+func NewSession(conn Connection) *Session {
+	s := &Session{
+		conn:      conn,
+		streams:   make(map[uint32]*Stream),
+		nextID:    1,
+		incoming:  make(chan *Stream, muxAcceptBacklog),
+		closeChan: make(chan struct{}),
+	}
+
+	go s.recvLoop()
+
+	return s
+}
+
+// Open opens a new stream to the peer
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.send(muxSYN, id, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Incoming delivers streams opened by the peer. It is closed when the session
+// closes. Streams the peer opens while muxAcceptBacklog of them are waiting
+// are refused with a FIN.
+func (s *Session) Incoming() <-chan *Stream {
+	return s.incoming
+}
+
+// Accept waits for the peer to open a stream and returns it, like
+// net.Listener.Accept, so the browser end can serve streams the peer pushes,
+// e.g. for reverse tunnels. Once the session is closed it returns ErrClosed,
+// or the error the session failed with, including to callers already
+// waiting. Accept and Incoming draw from the same queue.
+func (s *Session) Accept() (*Stream, error) {
+	st := <-s.incoming
+
+	// streams still queued when the session shut down have failed already
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Close closes every stream and the underlying Conn
+func (s *Session) Close() error {
+	s.shutdown(ErrClosed)
+	return s.conn.Close()
+}
+
+// shutdown fails every stream with err
+func (s *Session) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		close(s.incoming)
+		s.mu.Unlock()
+
+		for _, st := range streams {
+			st.fail(err)
+		}
+		close(s.closeChan)
+	})
+}
+
+func (s *Session) recvLoop() {
+	for {
+		data, err := s.conn.NextMessage()
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+
+		if len(data) < muxHeaderSize || int(binary.BigEndian.Uint32(data[5:9])) != len(data)-muxHeaderSize {
+			s.shutdown(ErrBadMuxFrame)
+			s.conn.Close()
+			return
+		}
+		typ := data[0]
+		id := binary.BigEndian.Uint32(data[1:5])
+		payload := data[muxHeaderSize:]
+
+		switch typ {
+		case muxSYN:
+			s.handleSYN(id)
+		case muxData:
+			if st := s.stream(id); st != nil {
+				st.push(payload)
+			}
+		case muxFIN:
+			if st := s.stream(id); st != nil {
+				st.remoteFIN()
+			}
+		default:
+			s.shutdown(ErrBadMuxFrame)
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+func (s *Session) handleSYN(id uint32) {
+	if id%2 != 0 {
+		// odd ids are ours to hand out
+		return
+	}
+
+	s.mu.Lock()
+	if s.err != nil || s.streams[id] != nil {
+		s.mu.Unlock()
+		return
+	}
+
+	// incoming is closed under mu, so the send can't race with shutdown
+	st := newStream(s, id)
+	select {
+	case s.incoming <- st:
+		s.streams[id] = st
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+		s.send(muxFIN, id, nil)
+	}
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) send(typ byte, id uint32, payload []byte) error {
+	frame := make([]byte, muxHeaderSize+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint32(frame[5:9], uint32(len(payload)))
+	copy(frame[muxHeaderSize:], payload)
+	return s.conn.Send(frame)
+}
+
+// Stream is one logical connection within a Session
+type Stream struct {
+	session *Session
+	id      uint32
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	eof    bool  // peer sent FIN
+	closed bool  // Close was called
+	err    error // session failure
+
+	writeMu sync.Mutex
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	st := &Stream{
+		session: s,
+		id:      id,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// ID returns the stream id
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read implements io.Reader. It returns io.EOF once the peer closed the stream
+// and everything it sent has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for len(st.buf) == 0 && !st.eof && !st.closed && st.err == nil {
+		st.cond.Wait()
+	}
+
+	switch {
+	case st.closed:
+		return 0, io.ErrClosedPipe
+	case len(st.buf) > 0:
+		n := copy(p, st.buf)
+		st.buf = st.buf[n:]
+		return n, nil
+	case st.err != nil:
+		return 0, st.err
+	}
+	return 0, io.EOF
+}
+
+// Write implements io.Writer, splitting p into data frames as needed
+func (st *Stream) Write(p []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	st.mu.Lock()
+	closed, err := st.closed, st.err
+	st.mu.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > muxMaxPayload {
+			chunk = chunk[:muxMaxPayload]
+		}
+		if err := st.session.send(muxData, st.id, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// Close sends FIN to the peer and stops reading. The stream is forgotten once
+// the peer has sent its FIN too.
+func (st *Stream) Close() error {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	st.buf = nil
+	eof, err := st.eof, st.err
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if err != nil {
+		return nil
+	}
+	if eof {
+		st.session.remove(st.id)
+	}
+	return st.session.send(muxFIN, st.id, nil)
+}
+
+func (st *Stream) push(data []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.closed || st.eof {
+		return
+	}
+	st.buf = append(st.buf, data...)
+	st.cond.Broadcast()
+}
+
+func (st *Stream) remoteFIN() {
+	st.mu.Lock()
+	st.eof = true
+	closed := st.closed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if closed {
+		st.session.remove(st.id)
+	}
+}
+
+func (st *Stream) fail(err error) {
+	st.mu.Lock()
+	st.err = err
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
@@ -0,0 +1,231 @@
+//go:build !js
+
+package wsjs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// muxFrame builds a raw mux frame as the peer would send it
+func muxFrame(typ byte, id uint32, payload []byte) []byte {
+	frame := make([]byte, muxHeaderSize+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint32(frame[5:9], uint32(len(payload)))
+	copy(frame[muxHeaderSize:], payload)
+	return frame
+}
+
+// readFrame reads the next mux frame the session sent to peer
+func readFrame(t *testing.T, peer *memConn) (typ byte, id uint32, payload []byte) {
+	t.Helper()
+
+	data, err := peer.NextMessage()
+	if err != nil {
+		t.Fatalf("peer NextMessage: %v", err)
+	}
+	if len(data) < muxHeaderSize {
+		t.Fatalf("short mux frame: %x", data)
+	}
+	return data[0], binary.BigEndian.Uint32(data[1:5]), data[muxHeaderSize:]
+}
+
+func newTestSession(t *testing.T) (*Session, *memConn) {
+	t.Helper()
+
+	local, peer := newMemConnPair(64)
+	s := NewSession(local)
+	t.Cleanup(func() {
+		s.Close()
+		peer.Close()
+	})
+	return s, peer
+}
+
+func TestSessionOpenReadWrite(t *testing.T) {
+	s, peer := newTestSession(t)
+
+	st, err := s.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if st.ID()%2 != 1 {
+		t.Fatalf("locally opened stream has even id %d", st.ID())
+	}
+	if typ, id, _ := readFrame(t, peer); typ != muxSYN || id != st.ID() {
+		t.Fatalf("got frame type %d id %d, want SYN for %d", typ, id, st.ID())
+	}
+
+	if _, err := st.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if typ, id, payload := readFrame(t, peer); typ != muxData || id != st.ID() || string(payload) != "ping" {
+		t.Fatalf("got frame type %d id %d payload %q", typ, id, payload)
+	}
+
+	peer.Send(muxFrame(muxData, st.ID(), []byte("pong")))
+	peer.Send(muxFrame(muxFIN, st.ID(), nil))
+
+	got, err := io.ReadAll(st)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "pong" {
+		t.Fatalf("read %q, want %q", got, "pong")
+	}
+}
+
+func TestStreamWriteSplitsLargePayloads(t *testing.T) {
+	s, peer := newTestSession(t)
+
+	st, err := s.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	readFrame(t, peer) // SYN
+
+	data := bytes.Repeat([]byte("x"), 2*muxMaxPayload+1)
+	if n, err := st.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+
+	var got []byte
+	for _, want := range []int{muxMaxPayload, muxMaxPayload, 1} {
+		_, _, payload := readFrame(t, peer)
+		if len(payload) != want {
+			t.Fatalf("frame carries %d bytes, want %d", len(payload), want)
+		}
+		got = append(got, payload...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("reassembled payload differs")
+	}
+}
+
+func TestSessionAccept(t *testing.T) {
+	s, peer := newTestSession(t)
+
+	peer.Send(muxFrame(muxSYN, 2, nil))
+	peer.Send(muxFrame(muxData, 2, []byte("hello")))
+
+	st, err := s.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if st.ID() != 2 {
+		t.Fatalf("accepted stream %d, want 2", st.ID())
+	}
+
+	buf := make([]byte, 16)
+	n, err := st.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+}
+
+func TestSessionIgnoresOddPeerIDs(t *testing.T) {
+	s, peer := newTestSession(t)
+
+	// odd ids belong to this end, so a SYN for one is ignored
+	peer.Send(muxFrame(muxSYN, 1, nil))
+	peer.Send(muxFrame(muxSYN, 4, nil))
+
+	st, err := s.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if st.ID() != 4 {
+		t.Fatalf("accepted stream %d, want 4", st.ID())
+	}
+}
+
+func TestSessionRefusesOverBacklog(t *testing.T) {
+	_, peer := newTestSession(t)
+
+	for i := 0; i <= muxAcceptBacklog; i++ {
+		peer.Send(muxFrame(muxSYN, uint32(2*(i+1)), nil))
+	}
+
+	typ, id, _ := readFrame(t, peer)
+	if want := uint32(2 * (muxAcceptBacklog + 1)); typ != muxFIN || id != want {
+		t.Fatalf("got frame type %d id %d, want FIN for %d", typ, id, want)
+	}
+}
+
+func TestSessionAcceptAfterClose(t *testing.T) {
+	s, _ := newTestSession(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Accept()
+		done <- err
+	}()
+
+	// let Accept block before closing
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("pending Accept returned %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending Accept did not return after Close")
+	}
+
+	if _, err := s.Accept(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Accept after Close returned %v, want ErrClosed", err)
+	}
+	if _, err := s.Open(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Open after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestSessionBadFrameFailsStreams(t *testing.T) {
+	s, peer := newTestSession(t)
+
+	st, err := s.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	readFrame(t, peer) // SYN
+
+	// length field disagrees with the payload
+	frame := muxFrame(muxData, st.ID(), []byte("abc"))
+	binary.BigEndian.PutUint32(frame[5:9], 7)
+	peer.Send(frame)
+
+	if _, err := st.Read(make([]byte, 8)); !errors.Is(err, ErrBadMuxFrame) {
+		t.Fatalf("Read returned %v, want ErrBadMuxFrame", err)
+	}
+}
+
+func TestStreamCloseSendsFIN(t *testing.T) {
+	s, peer := newTestSession(t)
+
+	st, err := s.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	readFrame(t, peer) // SYN
+
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if typ, id, _ := readFrame(t, peer); typ != muxFIN || id != st.ID() {
+		t.Fatalf("got frame type %d id %d, want FIN for %d", typ, id, st.ID())
+	}
+
+	if _, err := st.Write([]byte("late")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Write after Close returned %v, want io.ErrClosedPipe", err)
+	}
+	if _, err := st.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Read after Close returned %v, want io.ErrClosedPipe", err)
+	}
+}
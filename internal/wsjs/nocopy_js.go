@@ -0,0 +1,47 @@
+package wsjs
+
+import "syscall/js"
+
+// SendNoCopy is Send without allocating a new ArrayBuffer per frame: data is
+// copied into a scratch buffer kept by the Conn, grown to the largest frame
+// sent so far, and a view of it is handed to the socket. That is safe because
+// the browser copies a frame's bytes when it is sent (and a WebSocketStream
+// write is awaited), so the scratch buffer is free again once the call
+// returns. The trade-off is that the Conn holds on to a buffer as large as
+// its largest frame, which is why Send doesn't do this.
+//
+// A true zero-copy send is not possible: syscall/js has no way to view Go
+// memory as a typed array, and such a view would break whenever the wasm
+// memory grows. With write coalescing or SetMaxFrameBytes in effect the
+// payload takes the usual path.
+func (conn *Conn) SendNoCopy(data []byte) error {
+	if skip, err := conn.checkEmpty(data); skip || err != nil {
+		return err
+	}
+
+	if ok, err := conn.coalesce(data); ok || err != nil {
+		return err
+	}
+
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	if n := conn.maxFrame.Load(); n > 0 {
+		return conn.writeLogicalLocked(data, int(n))
+	}
+
+	if err := conn.checkOpenLocked(); err != nil {
+		return err
+	}
+	if err := conn.waitDrainLocked(); err != nil {
+		return err
+	}
+
+	if conn.scratch.IsUndefined() || conn.scratch.Get("byteLength").Int() < len(data) {
+		conn.scratch = _Uint8Array.New(len(data))
+	}
+	view := conn.scratch.Call("subarray", 0, len(data))
+	js.CopyBytesToJS(view, data)
+
+	return conn.sendFrameLocked(view, data)
+}
@@ -0,0 +1,50 @@
+package wsjs
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func benchmarkSend(b *testing.B, send func(conn *Conn, data []byte) error) {
+	for _, size := range []int{64, 4 << 10, 64 << 10} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			conn, err := (&Dialer{}).Dial(context.Background(), "ws://discard/")
+			if err != nil {
+				b.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			data := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := send(conn, data); err != nil {
+					b.Fatalf("send: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	benchmarkSend(b, (*Conn).Send)
+}
+
+func BenchmarkSendNoCopy(b *testing.B) {
+	benchmarkSend(b, (*Conn).SendNoCopy)
+}
+
+func TestSendNoCopyReusesScratch(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+
+	for _, m := range []string{"a longer first frame", "short", ""} {
+		if err := conn.SendNoCopy([]byte(m)); err != nil {
+			t.Fatalf("SendNoCopy: %v", err)
+		}
+		// the peer must see exactly this frame, not the rest of the scratch
+		if got := string(nextMessage(t, conn)); got != m {
+			t.Fatalf("echoed %q, want %q", got, m)
+		}
+	}
+}
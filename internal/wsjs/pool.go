@@ -0,0 +1,36 @@
+package wsjs
+
+import "sync"
+
+// readBufferClasses are the capacities pooled read buffers come in. Larger
+// messages are allocated directly and never pooled.
+var readBufferClasses = [...]int{512, 4 << 10, 16 << 10, 64 << 10, 256 << 10}
+
+var readBufferPools [len(readBufferClasses)]sync.Pool
+
+// getReadBuffer returns a buffer of length n, from the pool when a size class
+// fits it
+func getReadBuffer(n int) []byte {
+	for i, size := range readBufferClasses {
+		if n > size {
+			continue
+		}
+		if p, ok := readBufferPools[i].Get().(*[]byte); ok {
+			return (*p)[:n]
+		}
+		return make([]byte, n, size)
+	}
+	return make([]byte, n)
+}
+
+// putReadBuffer returns buf to its pool. Buffers that didn't come from
+// getReadBuffer are left to the garbage collector.
+func putReadBuffer(buf []byte) {
+	for i, size := range readBufferClasses {
+		if cap(buf) == size {
+			buf = buf[:0]
+			readBufferPools[i].Put(&buf)
+			return
+		}
+	}
+}
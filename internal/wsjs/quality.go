@@ -0,0 +1,132 @@
+package wsjs
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultQualityWindow is the sliding window used when none is given
+const DefaultQualityWindow = time.Minute
+
+const (
+	// RTTs at or below goodRTT cost nothing; the penalty grows linearly up
+	// to maxRTTPenalty at badRTT
+	goodRTT       = 100 * time.Millisecond
+	badRTT        = time.Second
+	maxRTTPenalty = 60
+
+	// every drop in the window costs dropPenalty, up to maxDropPenalty
+	dropPenalty    = 20
+	maxDropPenalty = 60
+)
+
+// QualityEstimator turns round-trip samples and disconnects observed over a
+// sliding window into a single link quality signal.
+type QualityEstimator struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	rtts  []rttSample
+	drops []time.Time
+}
+
+type rttSample struct {
+	at  time.Time
+	rtt time.Duration
+}
+
+// NewQualityEstimator creates an estimator looking back over window.
+// A non-positive window uses DefaultQualityWindow.
+func NewQualityEstimator(window time.Duration) *QualityEstimator {
+	if window <= 0 {
+		window = DefaultQualityWindow
+	}
+	return &QualityEstimator{
+		window: window,
+	}
+}
+
+// ObserveRTT records a round-trip time sample, e.g. from a ping
+func (q *QualityEstimator) ObserveRTT(rtt time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.expire(now)
+	q.rtts = append(q.rtts, rttSample{at: now, rtt: rtt})
+}
+
+// ObserveDrop records a disconnect or reconnect
+func (q *QualityEstimator) ObserveDrop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.expire(now)
+	q.drops = append(q.drops, now)
+}
+
+// Quality returns a score from 0 (unusable) to 100 (perfect) and a coarse
+// label: "good" (70 and up), "fair" (40 and up) or "poor". Without samples
+// in the window the link is assumed good.
+func (q *QualityEstimator) Quality() (score int, label string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire(time.Now())
+
+	score = 100
+
+	if len(q.rtts) > 0 {
+		var total time.Duration
+		for _, s := range q.rtts {
+			total += s.rtt
+		}
+		avg := total / time.Duration(len(q.rtts))
+
+		switch {
+		case avg >= badRTT:
+			score -= maxRTTPenalty
+		case avg > goodRTT:
+			score -= int(int64(maxRTTPenalty) * int64(avg-goodRTT) / int64(badRTT-goodRTT))
+		}
+	}
+
+	penalty := len(q.drops) * dropPenalty
+	if penalty > maxDropPenalty {
+		penalty = maxDropPenalty
+	}
+	score -= penalty
+
+	if score < 0 {
+		score = 0
+	}
+
+	switch {
+	case score >= 70:
+		label = "good"
+	case score >= 40:
+		label = "fair"
+	default:
+		label = "poor"
+	}
+	return score, label
+}
+
+// expire drops samples older than the window. It runs on every insert, so
+// memory stays bounded even if Quality is never called.
+func (q *QualityEstimator) expire(now time.Time) {
+	cutoff := now.Add(-q.window)
+
+	i := 0
+	for i < len(q.rtts) && q.rtts[i].at.Before(cutoff) {
+		i++
+	}
+	q.rtts = q.rtts[i:]
+
+	i = 0
+	for i < len(q.drops) && q.drops[i].Before(cutoff) {
+		i++
+	}
+	q.drops = q.drops[i:]
+}
@@ -0,0 +1,71 @@
+package wsjs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQualityWithoutSamples(t *testing.T) {
+	q := NewQualityEstimator(0)
+	if score, label := q.Quality(); score != 100 || label != "good" {
+		t.Fatalf("Quality = %d %q, want 100 good", score, label)
+	}
+}
+
+func TestQualityScores(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rtt   time.Duration
+		drops int
+		score int
+		label string
+	}{
+		{"fast", 50 * time.Millisecond, 0, 100, "good"},
+		{"halfway", 550 * time.Millisecond, 0, 70, "good"},
+		{"slow", 2 * time.Second, 0, 40, "fair"},
+		{"one drop", 50 * time.Millisecond, 1, 80, "good"},
+		{"drops capped", 50 * time.Millisecond, 10, 40, "fair"},
+		{"slow and dropping", 2 * time.Second, 3, 0, "poor"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewQualityEstimator(time.Minute)
+			q.ObserveRTT(tc.rtt)
+			for i := 0; i < tc.drops; i++ {
+				q.ObserveDrop()
+			}
+			if score, label := q.Quality(); score != tc.score || label != tc.label {
+				t.Fatalf("Quality = %d %q, want %d %q", score, label, tc.score, tc.label)
+			}
+		})
+	}
+}
+
+func TestQualityAveragesRTT(t *testing.T) {
+	q := NewQualityEstimator(time.Minute)
+	q.ObserveRTT(100 * time.Millisecond)
+	q.ObserveRTT(time.Second)
+
+	// average 550ms, halfway between good and bad
+	if score, _ := q.Quality(); score != 70 {
+		t.Fatalf("score = %d, want 70", score)
+	}
+}
+
+func TestQualityExpiresOnInsert(t *testing.T) {
+	q := NewQualityEstimator(time.Minute)
+	old := time.Now().Add(-2 * time.Minute)
+	for i := 0; i < 100; i++ {
+		q.rtts = append(q.rtts, rttSample{at: old, rtt: time.Second})
+		q.drops = append(q.drops, old)
+	}
+
+	q.ObserveRTT(10 * time.Millisecond)
+	q.ObserveDrop()
+
+	if len(q.rtts) != 1 || len(q.drops) != 1 {
+		t.Fatalf("kept %d RTTs and %d drops, want only the new ones", len(q.rtts), len(q.drops))
+	}
+	if score, _ := q.Quality(); score != 80 {
+		t.Fatalf("score = %d, want 80 from the samples in the window", score)
+	}
+}
@@ -0,0 +1,354 @@
+package wsjs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReconnectStatus is the coarse state of a ReconnectingConn
+type ReconnectStatus int
+
+const (
+	// StatusConnecting is the initial dial, before the first connection
+	StatusConnecting ReconnectStatus = iota
+	// StatusConnected means a connection is open and usable
+	StatusConnected
+	// StatusReconnecting means the connection dropped or a dial failed and
+	// another attempt is pending
+	StatusReconnecting
+	// StatusFailed means retries were exhausted; the ReconnectingConn is done
+	StatusFailed
+	// StatusClosed means Close was called; the ReconnectingConn is done
+	StatusClosed
+)
+
+func (s ReconnectStatus) String() string {
+	switch s {
+	case StatusConnecting:
+		return "connecting"
+	case StatusConnected:
+		return "connected"
+	case StatusReconnecting:
+		return "reconnecting"
+	case StatusFailed:
+		return "failed"
+	case StatusClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// ReconnectState is a snapshot of a ReconnectingConn's state
+type ReconnectState struct {
+	Status ReconnectStatus
+	// Attempt is the number of the pending reconnect attempt, 0 while connected
+	Attempt int
+	// Err is the error that caused the last disconnect or failed dial
+	Err error
+	// Delay is how long is waited before the pending attempt: the server's
+	// retry hint if it sent one (see Conn.RetryAfter), else the backoff
+	Delay time.Duration
+}
+
+// DefaultBackoff doubles the delay on every attempt, starting at 500ms and
+// capped at 30s.
+func DefaultBackoff(attempt int) time.Duration {
+	delay := 500 * time.Millisecond
+	for i := 1; i < attempt && delay < 30*time.Second; i++ {
+		delay *= 2
+	}
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+// ReconnectingConn keeps a WebSocket connection to uri open, redialing with
+// backoff whenever it drops. When the server closes with CloseTryAgainLater
+// and a retry hint, the hint replaces the backoff for that attempt.
+//
+// Frames in flight when a connection drops are lost; callers that need
+// continuity must resynchronize at the protocol level.
+type ReconnectingConn struct {
+	dialer     *Dialer
+	uri        string
+	maxRetries int
+	backoff    func(attempt int) time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	conn     *Conn
+	err      error // set once the ReconnectingConn is done
+	state    ReconnectState
+	onChange func(ReconnectState)
+
+	// session resumption, see SetResumption
+	extractToken func(data []byte) (token string, ok bool)
+	resumeURL    func(uri, token string) string
+	token        string
+
+	quality *QualityEstimator
+
+	closeChan chan struct{}
+	closeOnce sync.Once
+
+	// cancels a dial in progress on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewReconnectingConn starts connecting to uri in the background and returns
+// immediately in StatusConnecting. Every connection is dialed with d's
+// options; a nil d uses the defaults. maxRetries limits consecutive failed
+// attempts (0 or negative means retry forever); a nil backoff uses
+// DefaultBackoff.
+func NewReconnectingConn(d *Dialer, uri string, maxRetries int, backoff func(attempt int) time.Duration) *ReconnectingConn {
+	if d == nil {
+		d = &Dialer{}
+	}
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+
+	rc := &ReconnectingConn{
+		dialer:     d,
+		uri:        uri,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		state:      ReconnectState{Status: StatusConnecting},
+		closeChan:  make(chan struct{}),
+	}
+	rc.cond = sync.NewCond(&rc.mu)
+	rc.ctx, rc.cancel = context.WithCancel(context.Background())
+
+	go rc.run()
+
+	return rc
+}
+
+// State returns the current state
+func (rc *ReconnectingConn) State() ReconnectState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// OnStateChange registers fn to be called on every state transition.
+// Transitions are reported exactly once and in order, from a single
+// goroutine; fn must not block for long.
+func (rc *ReconnectingConn) OnStateChange(fn func(ReconnectState)) {
+	rc.mu.Lock()
+	rc.onChange = fn
+	rc.mu.Unlock()
+}
+
+// SetResumption makes reconnects resume the previous session instead of
+// starting fresh. extract sees every inbound frame (before it is queued for
+// NextMessage) and returns a token when the frame carries one; the latest
+// token is kept. On redial, resumeURL builds the URL to dial from the original
+// URL and that token, e.g. by adding it as a query parameter, since browsers
+// cannot add headers to the WebSocket handshake. Set it before the first
+// connection is established.
+func (rc *ReconnectingConn) SetResumption(extract func(data []byte) (token string, ok bool), resumeURL func(uri, token string) string) {
+	rc.mu.Lock()
+	rc.extractToken = extract
+	rc.resumeURL = resumeURL
+	rc.mu.Unlock()
+}
+
+// SetQualityEstimator reports every dropped connection to q, so its score
+// reflects how often the link has to be reestablished. It is also set on each
+// connection, which reports Ping round trips to it, see
+// Conn.SetQualityEstimator.
+func (rc *ReconnectingConn) SetQualityEstimator(q *QualityEstimator) {
+	rc.mu.Lock()
+	rc.quality = q
+	rc.mu.Unlock()
+}
+
+// dialURL returns the URL for the next dial, carrying the resume token if any
+func (rc *ReconnectingConn) dialURL() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.token == "" || rc.resumeURL == nil {
+		return rc.uri
+	}
+	return rc.resumeURL(rc.uri, rc.token)
+}
+
+// watchTokens captures resume tokens from frames received on conn
+func (rc *ReconnectingConn) watchTokens(conn *Conn) {
+	rc.mu.Lock()
+	extract := rc.extractToken
+	rc.mu.Unlock()
+
+	if extract == nil {
+		return
+	}
+
+	conn.SetMessageHandler(func(data []byte, isText bool) bool {
+		if token, ok := extract(data); ok {
+			rc.mu.Lock()
+			rc.token = token
+			rc.mu.Unlock()
+		}
+		return false
+	})
+}
+
+// run is the only place state transitions happen, which keeps them ordered
+func (rc *ReconnectingConn) run() {
+	attempt := 0
+	var delay time.Duration
+
+	for {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-rc.closeChan:
+				rc.finish(StatusClosed, ErrClosed)
+				return
+			}
+		}
+
+		conn, err := rc.dialer.Dial(rc.ctx, rc.dialURL())
+		select {
+		case <-rc.closeChan:
+			if err == nil {
+				conn.Close()
+			}
+			rc.finish(StatusClosed, ErrClosed)
+			return
+		default:
+		}
+
+		hinted := false
+		if err == nil {
+			attempt = 0
+			rc.watchTokens(conn)
+			rc.mu.Lock()
+			conn.SetQualityEstimator(rc.quality)
+			rc.mu.Unlock()
+			rc.setConn(conn, ReconnectState{Status: StatusConnected})
+
+			select {
+			case <-conn.closeChan:
+			case <-rc.closeChan:
+				conn.Close()
+				rc.finish(StatusClosed, ErrClosed)
+				return
+			}
+
+			err = conn.closedErr()
+			delay, hinted = conn.RetryAfter()
+
+			rc.mu.Lock()
+			quality := rc.quality
+			rc.mu.Unlock()
+			if quality != nil {
+				quality.ObserveDrop()
+			}
+		}
+
+		attempt++
+		if rc.maxRetries > 0 && attempt > rc.maxRetries {
+			rc.finish(StatusFailed, err)
+			return
+		}
+		if !hinted {
+			delay = rc.backoff(attempt)
+		}
+
+		rc.setConn(nil, ReconnectState{Status: StatusReconnecting, Attempt: attempt, Err: err, Delay: delay})
+	}
+}
+
+func (rc *ReconnectingConn) setConn(conn *Conn, state ReconnectState) {
+	rc.mu.Lock()
+	rc.conn = conn
+	rc.state = state
+	fn := rc.onChange
+	rc.cond.Broadcast()
+	rc.mu.Unlock()
+
+	if fn != nil {
+		fn(state)
+	}
+}
+
+func (rc *ReconnectingConn) finish(status ReconnectStatus, err error) {
+	rc.mu.Lock()
+	rc.conn = nil
+	if rc.err == nil {
+		rc.err = err
+	}
+	rc.state = ReconnectState{Status: status, Err: err}
+	state := rc.state
+	fn := rc.onChange
+	rc.cond.Broadcast()
+	rc.mu.Unlock()
+
+	if fn != nil {
+		fn(state)
+	}
+}
+
+// current waits for an open connection other than stale
+func (rc *ReconnectingConn) current(stale *Conn) (*Conn, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for rc.err == nil && (rc.conn == nil || rc.conn == stale) {
+		rc.cond.Wait()
+	}
+	if rc.err != nil {
+		return nil, rc.err
+	}
+	return rc.conn, nil
+}
+
+// NextMessage returns the next message, waiting across reconnects. It fails
+// only once the ReconnectingConn is closed or has given up.
+func (rc *ReconnectingConn) NextMessage() ([]byte, error) {
+	var stale *Conn
+	for {
+		conn, err := rc.current(stale)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := conn.NextMessage()
+		if err == nil {
+			return msg, nil
+		}
+		stale = conn
+	}
+}
+
+// Send sends data on the current connection, waiting for one if a reconnect
+// is in progress.
+func (rc *ReconnectingConn) Send(data []byte) error {
+	conn, err := rc.current(nil)
+	if err != nil {
+		return err
+	}
+	return conn.Send(data)
+}
+
+// Close stops reconnecting and closes the current connection
+func (rc *ReconnectingConn) Close() error {
+	rc.closeOnce.Do(func() {
+		close(rc.closeChan)
+		rc.cancel()
+
+		rc.mu.Lock()
+		if rc.err == nil {
+			rc.err = ErrClosed
+		}
+		rc.cond.Broadcast()
+		rc.mu.Unlock()
+	})
+	return nil
+}
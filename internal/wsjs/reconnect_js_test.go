@@ -0,0 +1,184 @@
+package wsjs
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stateRecorder collects the states a ReconnectingConn reports
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ReconnectState
+	change chan struct{}
+}
+
+func recordStates(rc *ReconnectingConn) *stateRecorder {
+	r := &stateRecorder{change: make(chan struct{}, 64)}
+	rc.OnStateChange(func(s ReconnectState) {
+		r.mu.Lock()
+		r.states = append(r.states, s)
+		r.mu.Unlock()
+		r.change <- struct{}{}
+	})
+	return r
+}
+
+// waitFor waits until status has been reported and returns that state
+func (r *stateRecorder) waitFor(t *testing.T, status ReconnectStatus) ReconnectState {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		r.mu.Lock()
+		for _, s := range r.states {
+			if s.Status == status {
+				r.mu.Unlock()
+				return s
+			}
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.change:
+		case <-timeout:
+			t.Fatalf("never reported %v, got %v", status, r.statuses())
+		}
+	}
+}
+
+// waitStates waits until n states have been reported and returns them
+func (r *stateRecorder) waitStates(t *testing.T, n int) []ReconnectState {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		r.mu.Lock()
+		if len(r.states) >= n {
+			states := append([]ReconnectState(nil), r.states...)
+			r.mu.Unlock()
+			return states
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.change:
+		case <-timeout:
+			t.Fatalf("got %v, want %d states", r.statuses(), n)
+		}
+	}
+}
+
+// checkStatuses fails unless exactly want has been reported, in order
+func (r *stateRecorder) checkStatuses(t *testing.T, want ...ReconnectStatus) []ReconnectState {
+	t.Helper()
+
+	states := r.waitStates(t, len(want))
+	// let a stray extra transition show up
+	waitTick()
+	if got := r.statuses(); !slices.Equal(got, want) {
+		t.Fatalf("reported %v, want %v", got, want)
+	}
+	return states
+}
+
+func (r *stateRecorder) statuses() []ReconnectStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]ReconnectStatus, len(r.states))
+	for i, s := range r.states {
+		statuses[i] = s.Status
+	}
+	return statuses
+}
+
+func TestReconnectingConnUsesDialer(t *testing.T) {
+	rc := NewReconnectingConn(&Dialer{Subprotocols: []string{"app"}}, "ws://echo/", 1, nil)
+	t.Cleanup(func() { rc.Close() })
+
+	if err := rc.Send([]byte("hi")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg, err := rc.NextMessage()
+	if err != nil || string(msg) != "hi" {
+		t.Fatalf("NextMessage = %q, %v", msg, err)
+	}
+	if got := lastSocket().Get("protocol").String(); got != "app" {
+		t.Fatalf("negotiated %q, want the Dialer's subprotocol", got)
+	}
+}
+
+func TestReconnectStateOrder(t *testing.T) {
+	rc := NewReconnectingConn(nil, "ws://echo/", 0, func(int) time.Duration { return time.Millisecond })
+	states := recordStates(rc)
+	t.Cleanup(func() { rc.Close() })
+
+	// the first dial is still pending, as the fake opens on a later tick
+	if got := rc.State().Status; got != StatusConnecting {
+		t.Fatalf("initial status %v, want connecting", got)
+	}
+
+	states.waitFor(t, StatusConnected)
+	first := lastSocket()
+	first.Call("_closed", 1006, "", false)
+	states.waitStates(t, 3)
+	if lastSocket().Equal(first) {
+		t.Fatal("reconnected without a new socket")
+	}
+
+	rc.Close()
+	got := states.checkStatuses(t, StatusConnected, StatusReconnecting, StatusConnected, StatusClosed)
+
+	if s := got[1]; s.Attempt != 1 || s.Delay != time.Millisecond || !errors.Is(s.Err, ErrClosed) {
+		t.Fatalf("reconnecting state %+v, want attempt 1 after the backoff's 1ms, for the drop", s)
+	}
+	if s := got[2]; s.Attempt != 0 || s.Err != nil {
+		t.Fatalf("connected state %+v, want attempt and error reset", s)
+	}
+	if s := got[3]; !errors.Is(s.Err, ErrClosed) {
+		t.Fatalf("closed state %+v, want ErrClosed", s)
+	}
+	if _, err := rc.NextMessage(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("NextMessage after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestReconnectCloseDuringBackoff(t *testing.T) {
+	// the server's hint of 7s replaces the 1ms backoff, so the redial is
+	// still pending when Close comes
+	rc := NewReconnectingConn(nil, "ws://echo/", 0, func(int) time.Duration { return time.Millisecond })
+	states := recordStates(rc)
+	t.Cleanup(func() { rc.Close() })
+
+	states.waitFor(t, StatusConnected)
+	lastSocket().Call("_closed", CloseTryAgainLater, "7", true)
+	if s := states.waitFor(t, StatusReconnecting); s.Delay != 7*time.Second {
+		t.Fatalf("reconnecting with delay %v, want the server's 7s", s.Delay)
+	}
+
+	sockets := fakeWebSocket.Get("sockets").Length()
+	rc.Close()
+	states.checkStatuses(t, StatusConnected, StatusReconnecting, StatusClosed)
+	if n := fakeWebSocket.Get("sockets").Length(); n != sockets {
+		t.Fatalf("dialed %d more sockets after Close", n-sockets)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	rc := NewReconnectingConn(nil, "ws://refuse/", 2, func(int) time.Duration { return time.Millisecond })
+	states := recordStates(rc)
+	t.Cleanup(func() { rc.Close() })
+
+	got := states.checkStatuses(t, StatusReconnecting, StatusReconnecting, StatusFailed)
+	for i, s := range got[:2] {
+		if s.Attempt != i+1 || s.Err == nil {
+			t.Fatalf("state %d is %+v, want attempt %d with the dial error", i, s, i+1)
+		}
+	}
+	if _, err := rc.NextMessage(); err == nil || errors.Is(err, ErrClosed) {
+		t.Fatalf("NextMessage after giving up returned %v, want the dial error", err)
+	}
+}
@@ -0,0 +1,79 @@
+package wsjs
+
+// ReadyState is the state of a Conn's underlying socket
+type ReadyState int
+
+// The values match WebSocket.readyState
+const (
+	StateConnecting ReadyState = iota
+	StateOpen
+	StateClosing
+	StateClosed
+)
+
+func (s ReadyState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateOpen:
+		return "open"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// State returns the socket's current ready state. WebSocketStream has no
+// readyState, so for it the state is derived from Close and the close event.
+func (conn *Conn) State() ReadyState {
+	select {
+	case <-conn.closeChan:
+		return StateClosed
+	default:
+	}
+
+	if conn.stream {
+		select {
+		case <-conn.opened:
+		default:
+			return StateConnecting
+		}
+		if conn.closed.Load() {
+			return StateClosing
+		}
+		return StateOpen
+	}
+	return ReadyState(conn.ws.Get("readyState").Int())
+}
+
+// Done returns a channel that is closed once the connection has closed
+func (conn *Conn) Done() <-chan struct{} {
+	return conn.closeChan
+}
+
+// StateChanges delivers the connection's transitions: StateOpen once open,
+// StateClosing when this end starts closing (a close initiated by the peer
+// goes straight to closed, as the browser doesn't report it earlier) and
+// StateClosed, after which the channel is closed. Every transition is
+// buffered, so a reader that comes late still sees all of them in order.
+func (conn *Conn) StateChanges() <-chan ReadyState {
+	return conn.stateChan
+}
+
+// emitState reports a transition on stateChan, which has room for every one
+// of them, so it never blocks
+func (conn *Conn) emitState(state ReadyState) {
+	conn.stateMu.Lock()
+	defer conn.stateMu.Unlock()
+
+	if conn.stateDone {
+		return
+	}
+	conn.stateChan <- state
+	if state == StateClosed {
+		conn.stateDone = true
+		close(conn.stateChan)
+	}
+}
@@ -0,0 +1,27 @@
+package wsjs
+
+// ConnStats counts the traffic that crossed a Conn. Byte counts are payload
+// bytes as handed to or received from the browser, without WebSocket framing
+// overhead.
+type ConnStats struct {
+	BytesSent        uint64
+	BytesReceived    uint64
+	MessagesSent     uint64
+	MessagesReceived uint64
+}
+
+// Stats returns the traffic counters. It is safe to call at any time,
+// concurrently with sends and receives.
+func (conn *Conn) Stats() ConnStats {
+	return ConnStats{
+		BytesSent:        conn.bytesSent.Load(),
+		BytesReceived:    conn.bytesReceived.Load(),
+		MessagesSent:     conn.messagesSent.Load(),
+		MessagesReceived: conn.messagesReceived.Load(),
+	}
+}
+
+func (conn *Conn) countSent(n int) {
+	conn.bytesSent.Add(uint64(n))
+	conn.messagesSent.Add(1)
+}
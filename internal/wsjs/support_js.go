@@ -0,0 +1,43 @@
+package wsjs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupported = errors.New("websocket not supported in this environment")
+
+// unsupportedReason is empty when every global this package needs exists.
+// It is computed once at init.
+var unsupportedReason = checkSupport()
+
+func checkSupport() string {
+	if !_WebSocket.Truthy() && !_WebSocketStream.Truthy() {
+		return "WebSocket is not available"
+	}
+	if !_ArrayBuffer.Truthy() {
+		return "ArrayBuffer is not available"
+	}
+	if !_Uint8Array.Truthy() {
+		return "Uint8Array is not available"
+	}
+	if !_Object.Truthy() {
+		return "Object is not available"
+	}
+	return ""
+}
+
+// Supported reports whether the JS environment provides the globals needed to
+// dial, and if not, why. Some non-browser runtimes lack WebSocket or typed
+// arrays; callers can check this up front instead of failing on first Dial.
+func Supported() (ok bool, reason string) {
+	return unsupportedReason == "", unsupportedReason
+}
+
+// errUnsupported returns a descriptive ErrUnsupported, or nil if supported
+func errUnsupported() error {
+	if unsupportedReason == "" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupported, unsupportedReason)
+}
@@ -0,0 +1,73 @@
+package wsjs
+
+import (
+	"bytes"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Direction tells whether a traced frame was sent or received
+type Direction int
+
+const (
+	DirectionIn Direction = iota
+	DirectionOut
+)
+
+func (d Direction) String() string {
+	if d == DirectionOut {
+		return "out"
+	}
+	return "in"
+}
+
+// Tracer is called for every frame a Conn sends or receives with its
+// direction, type and size in bytes. Keepalive pings and pongs are reported
+// as FrameControl. It runs on the sending goroutine or the browser's event
+// loop, so it must be quick and must not block.
+type Tracer func(dir Direction, frameType FrameType, n int)
+
+// SetTracer installs fn to be called for every frame, e.g. to correlate
+// client-side frame timing with server logs. Frames are traced as they are
+// handed to or come from the socket, so split sends and logical messages
+// show up frame by frame. Passing nil, the default, removes it; tracing
+// then costs a single nil check per frame.
+func (conn *Conn) SetTracer(fn Tracer) {
+	if fn == nil {
+		conn.tracer.Store(nil)
+		return
+	}
+	conn.tracer.Store(&fn)
+}
+
+// LogTracer returns a Tracer logging every frame at trace level, tagged with
+// url to tell connections apart
+func LogTracer(url string) Tracer {
+	return func(dir Direction, frameType FrameType, n int) {
+		log.Trace().Str("url", url).Stringer("dir", dir).Stringer("type", frameType).Int("size", n).Msg("[wsjs] Frame")
+	}
+}
+
+// trace reports a frame to the tracer, if any
+func (conn *Conn) trace(dir Direction, isText bool, data []byte) {
+	fn := conn.tracer.Load()
+	if fn == nil {
+		return
+	}
+
+	frameType := FrameBinary
+	if isText {
+		frameType = FrameText
+	} else if conn.isKeepalive(data) {
+		frameType = FrameControl
+	}
+	(*fn)(dir, frameType, len(data))
+}
+
+// isKeepalive reports whether data is a keepalive ping or pong
+func (conn *Conn) isKeepalive(data []byte) bool {
+	conn.keepaliveMu.Lock()
+	defer conn.keepaliveMu.Unlock()
+
+	return conn.keepaliveStop != nil && bytes.Equal(data, conn.keepalivePayload)
+}
@@ -0,0 +1,30 @@
+package wsjs
+
+import (
+	"errors"
+)
+
+var ErrMissingTypeTag = errors.New("websocket frame has no type tag")
+
+// SendTyped sends payload as one frame prefixed with a one-byte type tag.
+// An empty payload sends a frame holding just the tag.
+func (conn *Conn) SendTyped(typeTag byte, payload []byte) error {
+	frame := make([]byte, 1+len(payload))
+	frame[0] = typeTag
+	copy(frame[1:], payload)
+	return conn.Send(frame)
+}
+
+// NextTyped returns the next message split into its one-byte type tag and
+// payload. A frame holding only a tag yields an empty, non-nil payload; a
+// zero-length frame has no tag and returns ErrMissingTypeTag.
+func (conn *Conn) NextTyped() (typeTag byte, payload []byte, err error) {
+	msg, err := conn.NextMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(msg) == 0 {
+		return 0, nil, ErrMissingTypeTag
+	}
+	return msg[0], msg[1:], nil
+}
@@ -0,0 +1,37 @@
+package wsjs
+
+import (
+	"net/url"
+	"syscall/js"
+
+	"github.com/rs/zerolog/log"
+)
+
+// NormalizeURL upgrades a ws:// URL to wss:// when the page (or worker) is
+// served over HTTPS, since browsers block mixed-content ws:// connections
+// from secure pages with an unhelpful error. The upgrade applies to any host,
+// not just the page's own. Other URLs are returned unchanged.
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "ws" || !secureContext() {
+		return raw, nil
+	}
+
+	u.Scheme = "wss"
+	normalized := u.String()
+	log.Warn().Str("from", raw).Str("to", normalized).Msg("[wsjs] Upgraded ws:// to wss:// on a page served over HTTPS")
+	return normalized, nil
+}
+
+// secureContext reports whether the global location was served over HTTPS
+func secureContext() bool {
+	location := js.Global().Get("location")
+	if !location.Truthy() {
+		return false
+	}
+	return location.Get("protocol").String() == "https:"
+}
@@ -0,0 +1,141 @@
+package wsjs
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+)
+
+var _WebSocketStream = js.Global().Get("WebSocketStream")
+
+// webSocketStreamSupported reports whether the browser provides the
+// WebSocketStream API, which gives real backpressure on reads and writes
+// through WHATWG streams.
+func webSocketStreamSupported() bool {
+	return _WebSocketStream.Truthy()
+}
+
+// openStream starts connecting conn to uri over a WebSocketStream, with the
+// open attempt settling in the background. Reads are pulled by a goroutine only
+// as fast as NextMessage consumes them, and Send waits for each write to be
+// accepted by the stream.
+func (conn *Conn) openStream(uri string, d *Dialer) {
+	if len(d.Subprotocols) > 0 {
+		opts := _Object.New()
+		opts.Set("protocols", protocolList(d.Subprotocols))
+		conn.ws = _WebSocketStream.New(uri, opts)
+	} else {
+		conn.ws = _WebSocketStream.New(uri)
+	}
+	conn.stream = true
+
+	go func() {
+		opened, err := await(conn.ws.Get("opened"))
+		if err != nil {
+			// the closed watcher only starts once open, so report it here
+			conn.handleClose(1006, "", false)
+			conn.finishOpen(&DialError{URL: uri, Message: err.Error()}, d)
+			return
+		}
+
+		conn.protocol = opened.Get("protocol").String()
+		conn.extensions = opened.Get("extensions").String()
+		conn.writer = opened.Get("writable").Call("getWriter")
+		reader := opened.Get("readable").Call("getReader")
+
+		readDone := make(chan struct{})
+		go func() {
+			defer close(readDone)
+			for {
+				result, err := await(reader.Call("read"))
+				if err != nil || result.Get("done").Bool() {
+					return
+				}
+				conn.receive(result.Get("value"))
+			}
+		}()
+
+		go func() {
+			// closed rejects when the connection was not closed cleanly
+			code, reason, wasClean := 1006, "", false
+			info, err := await(conn.ws.Get("closed"))
+			if err == nil {
+				code = info.Get("closeCode").Int()
+				reason = info.Get("reason").String()
+				wasClean = true
+			}
+
+			// Deliver everything read before reporting the close
+			<-readDone
+			conn.handleClose(code, reason, wasClean)
+		}()
+
+		select {
+		case <-conn.closeChan:
+			conn.finishOpen(conn.closedErr(), d)
+		default:
+			conn.finishOpen(nil, d)
+		}
+	}()
+}
+
+// await blocks until promise settles and returns its result
+func await(promise js.Value) (js.Value, error) {
+	return awaitContext(context.Background(), promise)
+}
+
+// awaitContext is await that gives up waiting when ctx is done
+func awaitContext(ctx context.Context, promise js.Value) (js.Value, error) {
+	return awaitPromise(promise, ctx.Done(), ctx.Err)
+}
+
+// awaitUntil is await that gives up with ErrClosed once done is closed
+func awaitUntil(promise js.Value, done <-chan struct{}) (js.Value, error) {
+	return awaitPromise(promise, done, func() error { return ErrClosed })
+}
+
+func awaitPromise(promise js.Value, done <-chan struct{}, doneErr func() error) (js.Value, error) {
+	resultCh := make(chan js.Value, 1)
+	errCh := make(chan error, 1)
+
+	// Released once the promise settles, which may be after we stopped
+	// waiting for it
+	var onFulfilled, onRejected js.Func
+	release := func() {
+		onFulfilled.Release()
+		onRejected.Release()
+	}
+
+	onFulfilled = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		defer release()
+
+		if len(args) > 0 {
+			resultCh <- args[0]
+		} else {
+			resultCh <- js.Undefined()
+		}
+		return nil
+	})
+
+	onRejected = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		defer release()
+
+		if len(args) > 0 && args[0].Truthy() && args[0].Get("message").Type() == js.TypeString {
+			errCh <- errors.New(args[0].Get("message").String())
+		} else {
+			errCh <- ErrClosed
+		}
+		return nil
+	})
+
+	promise.Call("then", onFulfilled, onRejected)
+
+	select {
+	case result := <-resultCh:
+		return result, nil
+	case err := <-errCh:
+		return js.Undefined(), err
+	case <-done:
+		return js.Undefined(), doneErr()
+	}
+}
@@ -0,0 +1,52 @@
+package wsjs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamReadBackpressure(t *testing.T) {
+	useFakeWebSocketStream(t)
+	conn := dialTest(t, &Dialer{ReadBufferMessages: 1}, "stream")
+
+	frames := []string{"a", "b", "c", "d"}
+	for _, f := range frames {
+		lastStream().Call("deliver", f)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// one message buffered, one held by the reader, the rest left unread
+	if got := lastStream().Get("readController").Get("desiredSize").Int(); got != -2 {
+		t.Fatalf("readable desiredSize = %d, want -2", got)
+	}
+	if got := conn.State(); got != StateOpen {
+		t.Fatalf("State = %v with a full read buffer, want open", got)
+	}
+
+	for _, want := range frames {
+		if got := string(nextMessage(t, conn)); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStreamCloseWithFullReadBuffer(t *testing.T) {
+	useFakeWebSocketStream(t)
+	conn := dialTest(t, &Dialer{ReadBufferMessages: 1}, "stream")
+
+	for _, f := range []string{"a", "b", "c"} {
+		lastStream().Call("deliver", f)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind a reader waiting for room")
+	}
+}
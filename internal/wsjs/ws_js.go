@@ -1,80 +1,460 @@
 package wsjs
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall/js"
+	"time"
+	"unicode/utf8"
 )
 
 var (
-	ErrFailedToDial = errors.New("failed to dial websocket")
-	ErrClosed       = errors.New("websocket connection closed")
+	ErrFailedToDial    = errors.New("failed to dial websocket")
+	ErrEmptyFrame      = errors.New("websocket empty frame not allowed")
+	ErrReadOverflow    = errors.New("websocket read buffer overflow")
+	ErrUnexpectedFrame = errors.New("websocket unexpected frame type")
+	ErrNotOpen         = errors.New("websocket not open yet")
+	ErrInvalidText     = errors.New("websocket text frame is not valid unicode")
+	ErrNegativeSize    = errors.New("websocket negative payload size")
+
+	ErrInvalidCloseCode   = errors.New("websocket invalid close code")
+	ErrCloseReasonTooLong = errors.New("websocket close reason too long")
+)
+
+// maxCloseReason is the longest close reason, in bytes, a close frame can carry
+const maxCloseReason = 123
+
+// CloseUnsupportedData is the close code (1003) sent when the peer sends a
+// frame type the connection does not accept.
+const CloseUnsupportedData = 1003
+
+// CloseInvalidPayload is the close code (1007) sent when a message's data is
+// inconsistent with its type, such as a text frame that is not valid Unicode.
+const CloseInvalidPayload = 1007
+
+// CloseTryAgainLater is the close code (1013) a server sends to ask the client
+// to reconnect later. Its reason may carry a retry hint in seconds.
+const CloseTryAgainLater = 1013
+
+// MaxRetryAfter caps the retry hint a server can request on close.
+const MaxRetryAfter = 5 * time.Minute
+
+// ClosePolicyViolation is the close code (1008) sent when the connection is
+// failed for breaking a local limit, such as overflowing the read buffer.
+const ClosePolicyViolation = 1008
+
+// CloseMessageTooBig is the close code (1009) sent when the peer sends a
+// message larger than Dialer.MaxMessageBytes.
+const CloseMessageTooBig = 1009
+
+// DefaultMaxMessageBytes is the inbound message size limit used when
+// Dialer.MaxMessageBytes is zero.
+const DefaultMaxMessageBytes = 16 << 20
+
+// DefaultReadBufferMessages is the read buffer size used when
+// Dialer.ReadBufferMessages is zero.
+const DefaultReadBufferMessages = 128
+
+// drainPollInterval is how often bufferedAmount is polled while waiting for
+// outbound data to drain.
+const drainPollInterval = 10 * time.Millisecond
+
+// EmptyFramePolicy controls what Send does with a zero-length payload. It is
+// a policy rather than an allow flag because forbidding empty frames can mean
+// either telling the caller or quietly dropping them. Keepalive pings are
+// never empty (see DefaultKeepalivePayload), so StartKeepalive and Ping work
+// under every policy.
+type EmptyFramePolicy int
+
+const (
+	// EmptyFrameSend sends an empty binary frame. This is the default, for
+	// protocols that use empty frames as keepalives.
+	EmptyFrameSend EmptyFramePolicy = iota
+	// EmptyFrameError rejects the payload with ErrEmptyFrame.
+	EmptyFrameError
+	// EmptyFrameSkip silently drops the payload.
+	EmptyFrameSkip
+)
+
+// FrameType is a WebSocket data frame type. Values can be combined as a set.
+type FrameType int
+
+const (
+	FrameText FrameType = 1 << iota
+	FrameBinary
+	// FrameControl marks keepalive pings and pongs when tracing, see Tracer.
+	// Browsers hide real control frames, so it has no effect on
+	// AllowedFrameTypes.
+	FrameControl
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameText:
+		return "text"
+	case FrameBinary:
+		return "binary"
+	case FrameControl:
+		return "control"
+	}
+	return "FrameType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Dialer holds the options for opening connections. The zero value dials
+// with the defaults, like Dial. A Dialer may be used for many dials.
+//
+// Connections use the WebSocketStream API when the browser provides it, and
+// the classic WebSocket otherwise.
+type Dialer struct {
+	// AllowedFrameTypes restricts the data frame types the peer may send.
+	// Any other frame closes the connection with CloseUnsupportedData and
+	// makes NextMessage return ErrUnexpectedFrame. Zero allows both.
+	// FrameBinary alone rules out WsStream.CloseWrite, whose FIN is a text
+	// frame.
+	AllowedFrameTypes FrameType
+
+	// Subprotocols are offered to the server in preference order; the one
+	// it picks is reported by Conn.Subprotocol.
+	Subprotocols []string
+
+	// DiagnoseFailure makes a failed dial probe the URL over HTTP(S) so the
+	// error can include the status the server rejected the upgrade with
+	// (e.g. "server returned 403"). The probe only runs after a failure.
+	DiagnoseFailure bool
+
+	// ClassicWebSocket forces the classic WebSocket API even when the
+	// browser supports WebSocketStream.
+	ClassicWebSocket bool
+
+	// ReadBufferMessages is how many inbound messages may wait for
+	// NextMessage; zero uses DefaultReadBufferMessages. The classic WebSocket
+	// delivers frames on the browser's event loop, which must never block, so
+	// what happens to a frame arriving while the buffer is full is up to
+	// ReadOverflow. A WebSocketStream is instead read no further until the
+	// buffer has room, pushing back on the peer.
+	ReadBufferMessages int
+
+	// ReadOverflow is what to do when the read buffer of a classic WebSocket
+	// is full; it does not apply to a WebSocketStream
+	ReadOverflow ReadOverflowPolicy
+
+	// MaxMessageBytes caps the size of an inbound message; zero uses
+	// DefaultMaxMessageBytes and a negative value disables the cap. A larger
+	// message is dropped without being copied and the connection is closed
+	// with CloseMessageTooBig; NextMessage then returns a *CloseError with
+	// that code.
+	MaxMessageBytes int
+
+	// KeepaliveInterval, when positive, starts a keepalive sending
+	// KeepalivePayload (DefaultKeepalivePayload if empty) on every new
+	// connection, see Conn.StartKeepalive.
+	KeepaliveInterval time.Duration
+	KeepalivePayload  []byte
+
+	// WriteBufferHigh and WriteBufferLow, when WriteBufferHigh is positive,
+	// apply write backpressure, see Conn.SetWriteBufferLimits.
+	WriteBufferHigh int
+	WriteBufferLow  int
+
+	// EmptyFrames is what Send does with a zero-length payload; zero, the
+	// default, sends an empty frame as before. See SetEmptyFramePolicy.
+	EmptyFrames EmptyFramePolicy
+
+	// StrictText rejects text frames that don't survive conversion to a Go
+	// string intact: a JavaScript string with unpaired surrogates, e.g. from
+	// binary data a misconfigured peer sent as text, converts with U+FFFD
+	// replacing them. Such a frame closes the connection with
+	// CloseInvalidPayload and NextMessage returns ErrInvalidText instead of
+	// the corrupted bytes. Without it they are delivered as converted.
+	StrictText bool
+}
+
+// ReadOverflowPolicy controls what happens to inbound frames that arrive
+// while the read buffer is full.
+type ReadOverflowPolicy int
+
+const (
+	// ReadOverflowFail drops the frame and fails the connection: it is
+	// closed with ClosePolicyViolation and NextMessage returns
+	// ErrReadOverflow. This is the default, as it bounds memory.
+	ReadOverflowFail ReadOverflowPolicy = iota
+	// ReadOverflowBuffer keeps the frame in an unbounded overflow queue that
+	// is fed back, in order, as messages are consumed. Nothing is lost, at
+	// the cost of memory growing for as long as the reader falls behind.
+	ReadOverflowBuffer
 )
 
 var (
 	_WebSocket   = js.Global().Get("WebSocket")
 	_ArrayBuffer = js.Global().Get("ArrayBuffer")
 	_Uint8Array  = js.Global().Get("Uint8Array")
+	_Object      = js.Global().Get("Object")
 )
 
+var _ Connection = (*Conn)(nil)
+
 type Conn struct {
-	ws js.Value
+	// ws is a WebSocket, or a WebSocketStream when stream is set
+	ws     js.Value
+	stream bool
+	writer js.Value // WritableStream writer, stream only
 
-	messageChan chan []byte
+	url        string
+	protocol   string // negotiated subprotocol, set once open
+	extensions string // negotiated extensions, set once open
+
+	messageChan chan Message
 	closeChan   chan struct{}
+	closing     chan struct{} // closed once Close starts, to wake blocked sends
+
+	// closed once the open attempt settled, see WaitOpen
+	opened  chan struct{}
+	openErr error
+
+	// frames that didn't fit messageChan, see ReadOverflowBuffer
+	readOverflow ReadOverflowPolicy
+	overflowMu   sync.Mutex
+	overflow     []Message
+
+	// state transitions, see StateChanges
+	stateMu     sync.Mutex
+	stateChan   chan ReadyState
+	stateDone   bool
+	closingOnce sync.Once
+
+	// set by the close handler before closeChan is closed
+	closeCode   int
+	closeReason string
+	closeClean  bool
+
+	// protocol error detected on the event loop, reported once closed
+	err error
+
+	allowedFrames FrameType
+	emptyFrames   EmptyFramePolicy
+	maxMessage    int // inbound limit in bytes, none if not positive
+	strictText    bool
+
+	// frame splitting, see SetMaxFrameBytes; partial is only touched by
+	// the receive path
+	maxFrame       atomic.Int64
+	partial        []byte
+	partialStarted bool
+
+	// serializes sends so frames from concurrent callers don't interleave
+	sendMu    sync.Mutex
+	closed    atomic.Bool // set by Close while holding sendMu
+	closeOnce sync.Once
+
+	// traffic counters, see Stats
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	messagesSent     atomic.Uint64
+	messagesReceived atomic.Uint64
+
+	// pooled reads, see SetPooledReads
+	pooledReads atomic.Bool
+	lastMu      sync.Mutex
+	last        []byte // pooled buffer returned by the previous read
+
+	// write backpressure, see SetWriteBufferLimits; guarded by sendMu
+	writeHigh int
+	writeLow  int
+
+	handlerMu sync.Mutex
+	handler   func(data []byte, isText bool) bool
+
+	eventMu  sync.Mutex
+	events   *eventLog
+	openedAt time.Time // when the connection opened, for the "connect" event
+
+	tracer atomic.Pointer[Tracer] // see SetTracer
+
+	// keepalive, see StartKeepalive
+	keepaliveMu      sync.Mutex
+	keepaliveStop    chan struct{}
+	keepalivePayload []byte
+	keepalivePong    chan struct{} // wakes the keepalive on every pong
+	pongHandler      func([]byte)
+	pingWaiters      []chan struct{}   // see Ping
+	quality          *QualityEstimator // fed ping round trips, if set
+
+	// reused by SendNoCopy; guarded by sendMu
+	scratch js.Value
+
+	// write coalescing, see SetWriteCoalesceDelay
+	coalesceMu    sync.Mutex
+	coalesceDelay time.Duration
+	coalesceTimer *time.Timer
+	coalesceErr   error
+	pending       []byte
 
 	funcsToBeReleased []js.Func
+	funcsOnce         sync.Once
 }
 
-func (conn *Conn) freeFuncs() {
-	for _, f := range conn.funcsToBeReleased {
-		f.Release()
+// failProtocol records err and closes the socket with code and reason.
+// It must be called from the receive path.
+func (conn *Conn) failProtocol(err error, code int, reason string) {
+	conn.err = err
+	conn.closeSocket(code, reason)
+}
+
+// closedErr is the error to report once closeChan is closed
+func (conn *Conn) closedErr() error {
+	if conn.err != nil {
+		return conn.err
+	}
+	return &CloseError{
+		Code:     conn.closeCode,
+		Reason:   conn.closeReason,
+		WasClean: conn.closeClean,
 	}
 }
 
+func (conn *Conn) freeFuncs() {
+	conn.funcsOnce.Do(func() {
+		for _, f := range conn.funcsToBeReleased {
+			f.Release()
+		}
+	})
+}
+
+// Dial dials uri with the default options
 func Dial(uri string) (*Conn, error) {
-	errCh := make(chan error, 1)
+	return (&Dialer{}).Dial(context.Background(), uri)
+}
+
+// DialContext is Dial giving up when ctx is done
+func DialContext(ctx context.Context, uri string) (*Conn, error) {
+	return (&Dialer{}).Dial(ctx, uri)
+}
+
+// DialWithProtocols dials uri offering the given subprotocols
+func DialWithProtocols(uri string, protocols []string) (*Conn, error) {
+	return (&Dialer{Subprotocols: protocols}).Dial(context.Background(), uri)
+}
+
+// Dial dials uri with d's options, giving up when ctx is done. On
+// cancellation the socket is closed, its callbacks are released and
+// ctx.Err() is returned.
+func (d *Dialer) Dial(ctx context.Context, uri string) (*Conn, error) {
+	conn, err := d.NewConn(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.WaitOpen(ctx); err != nil {
+		if ctx.Err() != nil {
+			conn.Close()
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NewConn starts connecting to uri with the default options and returns
+// right away, in StateConnecting, see Dialer.NewConn
+func NewConn(uri string) (*Conn, error) {
+	return (&Dialer{}).NewConn(uri)
+}
+
+// NewConn starts connecting to uri with d's options and returns right away,
+// in StateConnecting, so the handshake can get going (DNS, TLS) before the
+// connection is needed. WaitOpen blocks until it is usable; sending before
+// then fails with ErrNotOpen. The keepalive, if configured, starts once the
+// connection opens. The error is only for a browser without WebSocket
+// support, a failed handshake is reported by WaitOpen.
+func (d *Dialer) NewConn(uri string) (*Conn, error) {
+	if err := errUnsupported(); err != nil {
+		return nil, err
+	}
+
+	allowed := d.AllowedFrameTypes
+	if allowed == 0 {
+		allowed = FrameText | FrameBinary
+	}
+
+	maxMessage := d.MaxMessageBytes
+	if maxMessage == 0 {
+		maxMessage = DefaultMaxMessageBytes
+	}
 
-	ws := _WebSocket.New(uri)
-	ws.Set("binaryType", "arraybuffer")
+	readBuffer := d.ReadBufferMessages
+	if readBuffer <= 0 {
+		readBuffer = DefaultReadBufferMessages
+	}
 
 	conn := &Conn{
-		ws:          ws,
-		messageChan: make(chan []byte, 128),
+		url:         uri,
+		messageChan: make(chan Message, readBuffer),
 		closeChan:   make(chan struct{}, 1),
+		closing:     make(chan struct{}),
+		opened:      make(chan struct{}),
+		stateChan:   make(chan ReadyState, 3),
+
+		allowedFrames: allowed,
+		readOverflow:  d.ReadOverflow,
+		maxMessage:    maxMessage,
+		strictText:    d.StrictText,
+		emptyFrames:   d.EmptyFrames,
+	}
+
+	if d.WriteBufferHigh > 0 {
+		conn.SetWriteBufferLimits(d.WriteBufferHigh, d.WriteBufferLow)
+	}
+
+	if !d.ClassicWebSocket && webSocketStreamSupported() {
+		conn.openStream(uri, d)
+		return conn, nil
+	}
+
+	errCh := make(chan error, 1)
+	// only the first of open and error matters; never block the event loop
+	settle := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+
+	if len(d.Subprotocols) > 0 {
+		conn.ws = _WebSocket.New(uri, protocolList(d.Subprotocols))
+	} else {
+		conn.ws = _WebSocket.New(uri)
 	}
+	conn.ws.Set("binaryType", "arraybuffer")
 
 	onOpen := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		errCh <- nil
+		settle(nil)
 		return nil
 	})
 
 	onError := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		errCh <- ErrFailedToDial
+		settle(dialErrorFromEvent(uri, args))
 		return nil
 	})
 
 	onMessage := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		jsData := args[0].Get("data")
-		if jsData.Type() == js.TypeString {
-			// text frame
-			data := []byte(jsData.String())
-
-			conn.messageChan <- data
-		} else if jsData.InstanceOf(_ArrayBuffer) {
-			// binary frame
-			array := _Uint8Array.New(jsData)
-			byteLength := array.Get("byteLength").Int()
-			data := make([]byte, byteLength)
-			js.CopyBytesToGo(data, array)
-
-			conn.messageChan <- data
-		}
-
+		conn.receive(args[0].Get("data"))
 		return nil
 	})
 
 	onClose := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		close(conn.closeChan)
+		code, reason, wasClean := 0, "", false
+		if len(args) > 0 {
+			code = args[0].Get("code").Int()
+			reason = args[0].Get("reason").String()
+			wasClean = args[0].Get("wasClean").Bool()
+		}
+		conn.handleClose(code, reason, wasClean)
 		return nil
 	})
 
@@ -85,36 +465,817 @@ func Dial(uri string) (*Conn, error) {
 	conn.ws.Call("addEventListener", "message", onMessage)
 	conn.ws.Call("addEventListener", "close", onClose)
 
-	err := <-errCh
-	if err != nil {
+	// Stop listening before releasing, so no event reaches a released func
+	abandon := func() {
+		conn.ws.Call("removeEventListener", "open", onOpen)
+		conn.ws.Call("removeEventListener", "error", onError)
+		conn.ws.Call("removeEventListener", "message", onMessage)
+		conn.ws.Call("removeEventListener", "close", onClose)
+		conn.ws.Call("close")
 		conn.freeFuncs()
-		return nil, err
+
+		// the close event may not have come yet; Close waits for it
+		select {
+		case <-conn.closeChan:
+		default:
+			conn.handleClose(1006, "", false)
+		}
 	}
 
+	go func() {
+		err := <-errCh
+		if err != nil {
+			if derr, ok := err.(*DialError); ok {
+				conn.addCloseDetail(derr)
+			}
+			abandon()
+			conn.finishOpen(err, d)
+			return
+		}
+
+		// A server may close right as the connection opens; don't hand out
+		// a conn that is already dead
+		select {
+		case <-conn.closeChan:
+			abandon()
+			conn.finishOpen(conn.closedErr(), d)
+			return
+		default:
+		}
+
+		conn.protocol = conn.ws.Get("protocol").String()
+		conn.extensions = conn.ws.Get("extensions").String()
+		conn.finishOpen(nil, d)
+	}()
+
 	return conn, nil
 }
 
+// finishOpen records the outcome of the open attempt, err being nil if the
+// connection opened, and wakes WaitOpen
+func (conn *Conn) finishOpen(err error, d *Dialer) {
+	if err != nil {
+		if conn.closed.Load() {
+			// Close was called while connecting
+			err = ErrClosed
+		} else if dialErr, ok := err.(*DialError); ok && d.DiagnoseFailure {
+			err = diagnoseDialFailure(conn.url, dialErr)
+		}
+	} else {
+		conn.logOpen()
+		conn.emitState(StateOpen)
+		if d.KeepaliveInterval > 0 {
+			conn.StartKeepalive(d.KeepaliveInterval, d.KeepalivePayload)
+		}
+	}
+
+	conn.openErr = err
+	close(conn.opened)
+}
+
+// WaitOpen blocks until the connection is open, returning nil, or until the
+// handshake fails, the connection is closed or ctx is done, returning the
+// reason. Giving up on ctx leaves the connection connecting, so it may be
+// waited for again or closed. It returns right away once the outcome is known.
+func (conn *Conn) WaitOpen(ctx context.Context) error {
+	select {
+	case <-conn.opened:
+		return conn.openErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func protocolList(protocols []string) js.Value {
+	list := make([]interface{}, len(protocols))
+	for i, p := range protocols {
+		list[i] = p
+	}
+	return js.ValueOf(list)
+}
+
+// Subprotocol returns the subprotocol selected by the server, or "" if none
+// was negotiated
+func (conn *Conn) Subprotocol() string {
+	return conn.protocol
+}
+
+// Extensions returns the extensions the server enabled, as in the
+// Sec-WebSocket-Extensions header (e.g. "permessage-deflate"). It is read
+// once the connection opens, so it is empty before StateOpen and when the
+// server enabled none.
+func (conn *Conn) Extensions() string {
+	return conn.extensions
+}
+
+// receive decodes an inbound frame (a string for text frames, an ArrayBuffer
+// or Uint8Array for binary ones) and queues it for NextMessage
+func (conn *Conn) receive(jsData js.Value) {
+	var data []byte
+	var isText, pooled bool
+
+	if conn.err != nil {
+		// closing after a protocol error, drop the rest
+		return
+	}
+
+	if jsData.Type() == js.TypeString {
+		// text frame
+		if conn.allowedFrames&FrameText == 0 {
+			conn.failProtocol(ErrUnexpectedFrame, CloseUnsupportedData, "text frames not accepted")
+			return
+		}
+		// a UTF-16 code unit takes at least one byte in UTF-8, so this
+		// rejects oversized strings before converting them. A primitive
+		// string has no properties for syscall/js, so box it to read length.
+		if conn.tooBig(_Object.Invoke(jsData).Get("length").Int()) {
+			return
+		}
+		data = []byte(jsData.String())
+		if conn.tooBig(len(data)) {
+			return
+		}
+		if conn.strictText && replacedText(jsData, data) {
+			conn.failProtocol(ErrInvalidText, CloseInvalidPayload, "invalid text")
+			return
+		}
+		isText = true
+	} else if jsData.InstanceOf(_ArrayBuffer) || jsData.InstanceOf(_Uint8Array) {
+		// binary frame
+		if conn.allowedFrames&FrameBinary == 0 {
+			conn.failProtocol(ErrUnexpectedFrame, CloseUnsupportedData, "binary frames not accepted")
+			return
+		}
+		array := jsData
+		if jsData.InstanceOf(_ArrayBuffer) {
+			array = _Uint8Array.New(jsData)
+		}
+		byteLength := array.Get("byteLength").Int()
+		if conn.tooBig(byteLength) {
+			return
+		}
+		if conn.pooledReads.Load() {
+			data = getReadBuffer(byteLength)
+			pooled = true
+		} else {
+			data = make([]byte, byteLength)
+		}
+		js.CopyBytesToGo(data, array)
+	} else {
+		return
+	}
+
+	conn.logEvent(logEvent{Event: "recv", Type: frameTypeName(isText), Size: len(data)})
+	conn.trace(DirectionIn, isText, data)
+	conn.bytesReceived.Add(uint64(len(data)))
+	conn.messagesReceived.Add(1)
+
+	if !isText && conn.maxFrame.Load() > 0 {
+		var ok bool
+		if data, ok = conn.reassemble(data, pooled); !ok {
+			return
+		}
+		pooled = false
+	}
+
+	if conn.handlePong(data) {
+		return
+	}
+	if conn.handleMessage(data, isText) {
+		return
+	}
+
+	conn.enqueue(Message{Data: data, Text: isText, pooled: pooled})
+}
+
+// replacedText reports whether converting the text frame str to data
+// introduced replacement characters, i.e. data has more U+FFFD than str
+func replacedText(str js.Value, data []byte) bool {
+	n := bytes.Count(data, []byte("\uFFFD"))
+	if n == 0 {
+		return false
+	}
+	return n > _Object.Invoke(str).Call("split", "\uFFFD").Length()-1
+}
+
+// tooBig fails the connection with CloseMessageTooBig if a message of n bytes
+// is over the limit
+func (conn *Conn) tooBig(n int) bool {
+	if conn.maxMessage <= 0 || n <= conn.maxMessage {
+		return false
+	}
+	err := &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	conn.failProtocol(err, err.Code, err.Reason)
+	return true
+}
+
+// enqueue queues msg for the readers without ever blocking the event loop,
+// applying the overflow policy when the read buffer is full. A stream is read
+// on its own goroutine instead, which waits for room.
+func (conn *Conn) enqueue(msg Message) {
+	if conn.stream {
+		select {
+		case conn.messageChan <- msg:
+		case <-conn.closing:
+			if msg.pooled {
+				putReadBuffer(msg.Data)
+			}
+		}
+		return
+	}
+
+	conn.overflowMu.Lock()
+	defer conn.overflowMu.Unlock()
+
+	// Once frames overflow, later ones queue behind them to keep order
+	if len(conn.overflow) == 0 {
+		select {
+		case conn.messageChan <- msg:
+			return
+		default:
+		}
+	}
+
+	if conn.readOverflow == ReadOverflowBuffer {
+		conn.overflow = append(conn.overflow, msg)
+		return
+	}
+
+	if msg.pooled {
+		putReadBuffer(msg.Data)
+	}
+	conn.failProtocol(ErrReadOverflow, ClosePolicyViolation, "read buffer overflow")
+}
+
+// refill moves overflowed frames into the read buffer as it frees up. Every
+// read from messageChan must call it.
+func (conn *Conn) refill() {
+	conn.overflowMu.Lock()
+	defer conn.overflowMu.Unlock()
+
+	for len(conn.overflow) > 0 {
+		select {
+		case conn.messageChan <- conn.overflow[0]:
+			conn.overflow[0] = Message{}
+			conn.overflow = conn.overflow[1:]
+		default:
+			return
+		}
+	}
+	conn.overflow = nil
+}
+
+// handleClose records the close info and wakes up everyone waiting on the
+// connection
+func (conn *Conn) handleClose(code int, reason string, wasClean bool) {
+	conn.closeCode = code
+	conn.closeReason = reason
+	conn.closeClean = wasClean
+	conn.closeEventLog(code, reason)
+	close(conn.closeChan)
+	conn.emitState(StateClosed)
+}
+
+// sendValue hands a string or ArrayBuffer to the socket
+func (conn *Conn) sendValue(v js.Value) error {
+	if conn.stream {
+		// a write stalled by backpressure must not hold up Close
+		_, err := awaitUntil(conn.writer.Call("write", v), conn.closing)
+		return err
+	}
+
+	conn.ws.Call("send", v)
+	return nil
+}
+
+// wireClose returns the code and reason a close with code and reason goes
+// out as. Browsers only let scripts send 1000 or 3000-4999 and throw on
+// anything else, so other codes (e.g. CloseUnsupportedData) go out as 1000
+// with the intended code prefixed to the reason.
+func wireClose(code int, reason string) (int, string) {
+	if code != 0 && code != 1000 && (code < 3000 || code > 4999) {
+		return 1000, strconv.Itoa(code) + " " + reason
+	}
+	return code, reason
+}
+
+// closeSocket starts the closing handshake; a zero code sends none. The code
+// is remapped by wireClose, and a reason that ends up too long is cut.
+func (conn *Conn) closeSocket(code int, reason string) {
+	conn.closingOnce.Do(func() {
+		conn.emitState(StateClosing)
+	})
+
+	code, reason = wireClose(code, reason)
+	if len(reason) > maxCloseReason {
+		// browsers throw on longer reasons; cut on a rune boundary
+		n := maxCloseReason
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+
+	if conn.stream {
+		info := _Object.New()
+		if code != 0 {
+			info.Set("closeCode", code)
+			info.Set("reason", reason)
+		}
+		conn.ws.Call("close", info)
+		return
+	}
+
+	if code == 0 {
+		conn.ws.Call("close")
+		return
+	}
+	conn.ws.Call("close", code, reason)
+}
+
+// bufferedAmount returns the bytes queued but not yet sent. Writes on a
+// WebSocketStream are awaited by Send, so nothing is ever left buffered.
+func (conn *Conn) bufferedAmount() int {
+	if conn.stream {
+		return 0
+	}
+	return conn.ws.Get("bufferedAmount").Int()
+}
+
+// Close closes the connection normally (1000) and waits until it is closed
 func (conn *Conn) Close() error {
-	conn.ws.Call("close")
+	return conn.CloseWithReason(CloseNormal, "")
+}
+
+// CloseWithReason flushes pending writes, closes the connection with code
+// and reason, and waits until it is closed. It is safe to call more than
+// once, also concurrently and mixed with Close: only the first call's code
+// and reason are sent, and every call returns once the connection is closed.
+//
+// code must be one an endpoint may send: 1000-1003, 1007-1014, or an
+// application code in 3000-4999. reason may be at most 123 bytes. Codes
+// browsers refuse to send go out as 1000 with the code prefixed to the
+// reason, e.g. "1002 ", which counts toward the limit, see wireClose.
+func (conn *Conn) CloseWithReason(code int, reason string) error {
+	if !validCloseCode(code) {
+		return ErrInvalidCloseCode
+	}
+	if _, wire := wireClose(code, reason); len(wire) > maxCloseReason {
+		return ErrCloseReasonTooLong
+	}
+
+	// Only the first call closes; later ones just wait for it to finish
+	conn.closeOnce.Do(func() {
+		conn.stopKeepalive()
+		// wake sends stuck behind a stalled link before queueing behind them;
+		// pending coalesced data is then only sent if the link can take it
+		close(conn.closing)
+		conn.Flush()
+
+		conn.sendMu.Lock()
+		conn.closed.Store(true)
+		conn.sendMu.Unlock()
+
+		conn.closeSocket(code, reason)
+		<-conn.closeChan
+		conn.freeFuncs()
+	})
 	<-conn.closeChan
-	conn.freeFuncs()
 	return nil
 }
 
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// SetMessageHandler installs fn to inspect every inbound frame before it is
+// queued for NextMessage. If fn returns true the frame is considered handled
+// and is not queued. Passing nil removes the handler. It is safe to swap the
+// handler at any time, e.g. when a protocol moves past its handshake phase.
+func (conn *Conn) SetMessageHandler(fn func(data []byte, isText bool) bool) {
+	conn.handlerMu.Lock()
+	conn.handler = fn
+	conn.handlerMu.Unlock()
+}
+
+func (conn *Conn) handleMessage(data []byte, isText bool) bool {
+	conn.handlerMu.Lock()
+	fn := conn.handler
+	conn.handlerMu.Unlock()
+
+	return fn != nil && fn(data, isText)
+}
+
 func (conn *Conn) NextMessage() ([]byte, error) {
+	msg, err := conn.nextFrame()
+	return msg.Data, err
+}
+
+// NextFrame returns the next message along with whether it arrived as a text
+// frame, for protocols that care about the frame opcode.
+func (conn *Conn) NextFrame() (data []byte, isText bool, err error) {
+	msg, err := conn.nextFrame()
+	return msg.Data, msg.Text, err
+}
+
+// NextMessageContext is like NextMessage but gives up with ctx.Err() once ctx
+// is done. A ctx that is already done returns immediately without consuming a
+// queued message.
+func (conn *Conn) NextMessageContext(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-conn.messageChan:
+		conn.refill()
+		return conn.take(msg).Data, nil
+	case <-conn.closeChan:
+		return nil, conn.closedErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (conn *Conn) nextFrame() (Message, error) {
 	select {
 	case msg := <-conn.messageChan:
-		return msg, nil
+		conn.refill()
+		return conn.take(msg), nil
 	case <-conn.closeChan:
-		return nil, ErrClosed
+		return Message{}, conn.closedErr()
 	}
 }
 
+// SetPooledReads makes binary messages arrive in buffers taken from a pool,
+// cutting allocations and GC pauses on busy connections. This changes who
+// owns returned data: a slice returned by NextMessage, NextFrame or
+// NextMessageContext is only valid until the next of those calls, when it is
+// recycled, so callers must copy anything they keep. WsStream recycles
+// buffers itself once their bytes have been read. Off by default.
+func (conn *Conn) SetPooledReads(enabled bool) {
+	conn.pooledReads.Store(enabled)
+}
+
+// take recycles the pooled buffer handed out by the previous read and
+// remembers msg's, if pooled
+func (conn *Conn) take(msg Message) Message {
+	conn.lastMu.Lock()
+	defer conn.lastMu.Unlock()
+
+	if conn.last != nil {
+		putReadBuffer(conn.last)
+		conn.last = nil
+	}
+	if msg.pooled {
+		conn.last = msg.Data
+	}
+	return msg
+}
+
+// SetEmptyFramePolicy sets how Send handles zero-length payloads.
+// Set it before sending; the default is EmptyFrameSend.
+func (conn *Conn) SetEmptyFramePolicy(policy EmptyFramePolicy) {
+	conn.emptyFrames = policy
+}
+
+// Send sends data as a binary frame. data is copied into a new ArrayBuffer
+// before Send returns, and neither the browser nor the Conn keeps a
+// reference to it, so the caller may reuse data right away.
 func (conn *Conn) Send(data []byte) error {
+	if skip, err := conn.checkEmpty(data); skip || err != nil {
+		return err
+	}
+
+	if ok, err := conn.coalesce(data); ok || err != nil {
+		return err
+	}
+
+	return conn.writeFrame(data)
+}
+
+// SendText sends s as a text frame, for peers that expect text opcodes, e.g.
+// for JSON control messages. It bypasses write coalescing, flushing pending
+// binary data first so frames keep their order.
+func (conn *Conn) SendText(s string) error {
+	if s == "" {
+		if skip, err := conn.checkEmpty(nil); skip || err != nil {
+			return err
+		}
+	}
+
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	if err := conn.flushLocked(); err != nil {
+		return err
+	}
+
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	if err := conn.checkOpenLocked(); err != nil {
+		return err
+	}
+	if err := conn.waitDrainLocked(); err != nil {
+		return err
+	}
+
+	if err := conn.sendValue(js.ValueOf(s)); err != nil {
+		return err
+	}
+	conn.logEvent(logEvent{Event: "send", Type: "text", Size: len(s)})
+	if fn := conn.tracer.Load(); fn != nil {
+		(*fn)(DirectionOut, FrameText, len(s))
+	}
+	conn.countSent(len(s))
+	return nil
+}
+
+// sendReaderChunk is how much SendReader copies into the frame at a time
+const sendReaderChunk = 32 * 1024
+
+// SendReader sends exactly size bytes read from r as one binary frame. The
+// bytes are copied chunk by chunk straight into the frame's ArrayBuffer, so
+// the payload is never held in Go memory as a whole; it is held in JS memory
+// though, as every frame is filled before the first is sent. Nothing more than size
+// bytes is read from r; if r ends before size bytes, nothing is sent and
+// io.ErrUnexpectedEOF is returned. Like SendText it flushes pending coalesced
+// data first. With SetMaxFrameBytes in effect the payload is split into
+// frames like any other send. A negative size fails with ErrNegativeSize,
+// and nothing is read from r if the connection is not open.
+func (conn *Conn) SendReader(r io.Reader, size int) error {
+	if size < 0 {
+		return ErrNegativeSize
+	}
+	// don't consume r for a send that can't happen
+	if err := conn.checkOpen(); err != nil {
+		return err
+	}
+	if size == 0 {
+		if skip, err := conn.checkEmpty(nil); skip || err != nil {
+			return err
+		}
+	}
+
+	frameSize, header := size, 0
+	if n := conn.maxFrame.Load(); n > 0 {
+		frameSize, header = int(n), 1
+	}
+
+	var frames []js.Value
+	chunk := make([]byte, min(size, sendReaderChunk))
+	for off, first := 0, true; first || off < size; first = false {
+		end := off + min(frameSize, size-off)
+		buffer := _ArrayBuffer.New(header + end - off)
+		array := _Uint8Array.New(buffer)
+		if header > 0 {
+			array.SetIndex(0, logicalHeader(first, end == size))
+		}
+
+		for start := off; off < end; {
+			n, err := io.ReadFull(r, chunk[:min(len(chunk), end-off)])
+			if n > 0 {
+				pos := header + off - start
+				js.CopyBytesToJS(array.Call("subarray", pos, pos+n), chunk[:n])
+				off += n
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return err
+			}
+		}
+		frames = append(frames, buffer)
+	}
+
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	if err := conn.flushLocked(); err != nil {
+		return err
+	}
+
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	for _, buffer := range frames {
+		if err := conn.checkOpenLocked(); err != nil {
+			return err
+		}
+		if err := conn.waitDrainLocked(); err != nil {
+			return err
+		}
+
+		n := buffer.Get("byteLength").Int()
+		if err := conn.sendValue(buffer); err != nil {
+			return err
+		}
+		conn.logEvent(logEvent{Event: "send", Type: "binary", Size: n})
+		if fn := conn.tracer.Load(); fn != nil {
+			(*fn)(DirectionOut, FrameBinary, n)
+		}
+		conn.countSent(n)
+	}
+	return nil
+}
+
+// checkEmpty applies the empty frame policy to data
+func (conn *Conn) checkEmpty(data []byte) (skip bool, err error) {
+	if len(data) > 0 {
+		return false, nil
+	}
+
+	switch conn.emptyFrames {
+	case EmptyFrameError:
+		return true, ErrEmptyFrame
+	case EmptyFrameSkip:
+		return true, nil
+	}
+	return false, nil
+}
+
+// writeFrame sends data as one binary frame, or as a run of them when
+// SetMaxFrameBytes is in effect
+func (conn *Conn) writeFrame(data []byte) error {
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	if n := conn.maxFrame.Load(); n > 0 {
+		return conn.writeLogicalLocked(data, int(n))
+	}
+	return conn.writeFrameLocked(data)
+}
+
+func (conn *Conn) writeFrameLocked(data []byte) error {
+	if err := conn.checkOpenLocked(); err != nil {
+		return err
+	}
+	if err := conn.waitDrainLocked(); err != nil {
+		return err
+	}
+
 	buffer := _ArrayBuffer.New(len(data))
 	array := _Uint8Array.New(buffer)
 	js.CopyBytesToJS(array, data)
 
-	conn.ws.Call("send", buffer)
+	return conn.sendFrameLocked(buffer, data)
+}
+
+// sendFrameLocked hands v, a binary frame holding data, to the socket and
+// accounts for it. sendMu must be held.
+func (conn *Conn) sendFrameLocked(v js.Value, data []byte) error {
+	if err := conn.sendValue(v); err != nil {
+		return err
+	}
+	conn.logEvent(logEvent{Event: "send", Type: "binary", Size: len(data)})
+	conn.trace(DirectionOut, false, data)
+	conn.countSent(len(data))
+	return nil
+}
+
+// checkOpenLocked fails with ErrNotOpen until the connection opened and with
+// ErrClosed once Close was called or the socket closed, so nothing is handed
+// to a WebSocket that can't take it. sendMu must be held.
+func (conn *Conn) checkOpenLocked() error {
+	return conn.checkOpen()
+}
+
+// checkOpen is checkOpenLocked for an early check without sendMu, e.g. before
+// preparing a payload. Close may still win the race, so the send itself must
+// check again under sendMu.
+func (conn *Conn) checkOpen() error {
+	if conn.closed.Load() {
+		return ErrClosed
+	}
+	select {
+	case <-conn.opened:
+	default:
+		return ErrNotOpen
+	}
+	select {
+	case <-conn.closeChan:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+// SetWriteBufferLimits makes sends wait while more than high bytes are queued
+// in the browser (bufferedAmount), until the queue drains to low or below.
+// This bounds memory under sustained upload, where Send would otherwise
+// return instantly and let the browser buffer everything. A non-positive
+// high, the default, disables the limit; low is clamped to [0, high].
+func (conn *Conn) SetWriteBufferLimits(high, low int) {
+	if low > high {
+		low = high
+	}
+	if low < 0 {
+		low = 0
+	}
+
+	conn.sendMu.Lock()
+	conn.writeHigh = high
+	conn.writeLow = low
+	conn.sendMu.Unlock()
+}
+
+// waitDrainLocked applies the write buffer limits. sendMu must be held.
+func (conn *Conn) waitDrainLocked() error {
+	if conn.writeHigh <= 0 || conn.bufferedAmount() <= conn.writeHigh {
+		return nil
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for conn.bufferedAmount() > conn.writeLow {
+		select {
+		case <-ticker.C:
+		case <-conn.closing:
+			return ErrClosed
+		case <-conn.closeChan:
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// Barrier blocks until every frame sent before it has been handed to the
+// network (bufferedAmount reaches zero). Sends from other goroutines wait for
+// the barrier to complete, so a frame sent after Barrier returns is ordered
+// after all earlier ones have been flushed. Data held back by write
+// coalescing is sent first.
+func (conn *Conn) Barrier(ctx context.Context) error {
+	conn.coalesceMu.Lock()
+	defer conn.coalesceMu.Unlock()
+
+	if err := conn.flushLocked(); err != nil {
+		return err
+	}
+
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for conn.bufferedAmount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-conn.closing:
+			return ErrClosed
+		case <-conn.closeChan:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	return nil
 }
+
+// CloseCode returns the close code and reason received when the connection
+// closed. ok is false while the connection is still open.
+func (conn *Conn) CloseCode() (code int, reason string, ok bool) {
+	select {
+	case <-conn.closeChan:
+		return conn.closeCode, conn.closeReason, true
+	default:
+		return 0, "", false
+	}
+}
+
+// RetryAfter reports how long the server asked the client to wait before
+// reconnecting. A hint is only present when the connection was closed with
+// CloseTryAgainLater and a numeric seconds reason; it is capped to
+// MaxRetryAfter. ok is false while the connection is open or without a hint.
+func (conn *Conn) RetryAfter() (delay time.Duration, ok bool) {
+	code, reason, closed := conn.CloseCode()
+	if !closed {
+		return 0, false
+	}
+	return parseRetryAfter(code, reason)
+}
+
+func parseRetryAfter(code int, reason string) (time.Duration, bool) {
+	if code != CloseTryAgainLater {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(strings.TrimSpace(reason))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	if seconds > int(MaxRetryAfter/time.Second) {
+		return MaxRetryAfter, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
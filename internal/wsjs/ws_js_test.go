@@ -0,0 +1,285 @@
+package wsjs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+func TestDialFailsWhenClosedDuringOpen(t *testing.T) {
+	conn, err := (&Dialer{}).Dial(testContext(t), "ws://close-on-open/")
+	if err == nil {
+		conn.Close()
+		t.Fatal("Dial handed out a connection that closed while opening")
+	}
+
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 1001 {
+		t.Fatalf("Dial returned %v, want a *CloseError with code 1001", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	_, err := (&Dialer{}).Dial(testContext(t), "ws://refuse/")
+
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || !errors.Is(err, ErrFailedToDial) {
+		t.Fatalf("Dial returned %v, want a *DialError", err)
+	}
+	if dialErr.URL != "ws://refuse/" || dialErr.Code != 1006 {
+		t.Fatalf("DialError = %+v, want URL ws://refuse/ and code 1006", dialErr)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := (&Dialer{}).Dial(ctx, "ws://hang/")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Dial returned %v, want context.DeadlineExceeded", err)
+	}
+	if state := lastSocket().Get("readyState").Int(); state < 2 {
+		t.Fatalf("abandoned socket left in readyState %d", state)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if err := conn.CloseWithReason(4000, "late"); err != nil {
+		t.Fatalf("CloseWithReason after Close: %v", err)
+	}
+
+	if code, _, ok := conn.CloseCode(); !ok || code != CloseNormal {
+		t.Fatalf("CloseCode = %d, %v, want %d from the first Close", code, ok, CloseNormal)
+	}
+	if err := conn.Send([]byte("x")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Send after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+
+	done := make(chan error, 4)
+	for i := 0; i < cap(done); i++ {
+		go func() { done <- conn.Close() }()
+	}
+	for i := 0; i < cap(done); i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("concurrent Close did not return")
+		}
+	}
+	if got := conn.State(); got != StateClosed {
+		t.Fatalf("State = %v, want closed", got)
+	}
+}
+
+func TestCloseWakesStalledSend(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		wait func(conn *Conn) error
+	}{
+		{"Send", func(conn *Conn) error { return conn.Send([]byte("x")) }},
+		{"Barrier", func(conn *Conn) error { return conn.Barrier(context.Background()) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := dialTest(t, nil, "sink")
+			conn.SetWriteBufferLimits(10, 0)
+			// the link takes nothing more, so the buffer never drains
+			lastSocket().Set("bufferedAmount", 1000)
+
+			blocked := make(chan error, 1)
+			go func() { blocked <- tc.wait(conn) }()
+			time.Sleep(10 * time.Millisecond)
+			select {
+			case err := <-blocked:
+				t.Fatalf("%s returned %v on a stalled link, want it to block", tc.name, err)
+			default:
+			}
+
+			closed := make(chan error, 1)
+			go func() { closed <- conn.Close() }()
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Close blocked behind a stalled %s", tc.name)
+			}
+			if err := <-blocked; !errors.Is(err, ErrClosed) {
+				t.Fatalf("stalled %s returned %v, want ErrClosed", tc.name, err)
+			}
+		})
+	}
+}
+
+func TestSendReader(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+
+	if err := conn.SendReader(strings.NewReader("hello world"), 5); err != nil {
+		t.Fatalf("SendReader: %v", err)
+	}
+	sent := lastSocket().Get("sent")
+	if n := sent.Length(); n != 1 || sent.Index(0).Get("byteLength").Int() != 5 {
+		t.Fatalf("sent %d frames, want one of 5 bytes", n)
+	}
+
+	if err := conn.SendReader(strings.NewReader("x"), -1); !errors.Is(err, ErrNegativeSize) {
+		t.Fatalf("SendReader of size -1 returned %v, want ErrNegativeSize", err)
+	}
+	if err := conn.SendReader(strings.NewReader("abc"), 4); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("SendReader of a short reader returned %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	conn.Close()
+	r := strings.NewReader("unread")
+	if err := conn.SendReader(r, r.Len()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("SendReader after Close returned %v, want ErrClosed", err)
+	}
+	if r.Len() != len("unread") {
+		t.Fatal("SendReader consumed the reader of a closed conn")
+	}
+}
+
+func TestCloseReasonLimit(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+
+	// 1002 goes out as 1000 with "1002 " prefixed, leaving 118 bytes
+	if err := conn.CloseWithReason(1002, strings.Repeat("x", 119)); !errors.Is(err, ErrCloseReasonTooLong) {
+		t.Fatalf("CloseWithReason returned %v, want ErrCloseReasonTooLong", err)
+	}
+	if err := conn.CloseWithReason(4000, strings.Repeat("x", 124)); !errors.Is(err, ErrCloseReasonTooLong) {
+		t.Fatalf("CloseWithReason returned %v, want ErrCloseReasonTooLong", err)
+	}
+
+	reason := strings.Repeat("x", 118)
+	if err := conn.CloseWithReason(1002, reason); err != nil {
+		t.Fatalf("CloseWithReason: %v", err)
+	}
+	if code, got, _ := conn.CloseCode(); code != 1000 || got != "1002 "+reason {
+		t.Fatalf("closed with %d %q, want the full reason behind the code", code, got)
+	}
+}
+
+func TestStatsCountTraffic(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+
+	payloads := [][]byte{make([]byte, 100), make([]byte, 1), make([]byte, 4096)}
+	total := 0
+	for _, p := range payloads {
+		if err := conn.Send(p); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		total += len(p)
+	}
+	if err := conn.SendText("hello"); err != nil {
+		t.Fatalf("SendText: %v", err)
+	}
+	total += len("hello")
+
+	for range len(payloads) + 1 {
+		nextMessage(t, conn)
+	}
+
+	want := ConnStats{
+		BytesSent:        uint64(total),
+		BytesReceived:    uint64(total),
+		MessagesSent:     uint64(len(payloads) + 1),
+		MessagesReceived: uint64(len(payloads) + 1),
+	}
+	if got := conn.Stats(); got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	conn := dialTest(t, nil, "echo")
+
+	if err := conn.SendText(`{"op":"héllo"}`); err != nil {
+		t.Fatalf("SendText: %v", err)
+	}
+	if err := conn.Send([]byte{0, 1, 2}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	data, isText, err := conn.NextFrame()
+	if err != nil || !isText || string(data) != `{"op":"héllo"}` {
+		t.Fatalf("NextFrame = %q, %v, %v, want the text frame", data, isText, err)
+	}
+	data, isText, err = conn.NextFrame()
+	if err != nil || isText || string(data) != "\x00\x01\x02" {
+		t.Fatalf("NextFrame = %q, %v, %v, want the binary frame", data, isText, err)
+	}
+
+	if sent := lastSocket().Get("sent").Index(0); sent.Type() != js.TypeString {
+		t.Fatalf("SendText handed the socket a %v, want a string", sent.Type())
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	conn, err := NewConn("ws://sink/?extensions=permessage-deflate")
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.Extensions(); got != "" {
+		t.Fatalf("Extensions before open = %q, want none", got)
+	}
+	if err := conn.WaitOpen(testContext(t)); err != nil {
+		t.Fatalf("WaitOpen: %v", err)
+	}
+	if got := conn.Extensions(); got != "permessage-deflate" {
+		t.Fatalf("Extensions = %q, want permessage-deflate", got)
+	}
+
+	if got := dialTest(t, nil, "sink").Extensions(); got != "" {
+		t.Fatalf("Extensions = %q with none enabled, want none", got)
+	}
+}
+
+func TestStrictText(t *testing.T) {
+	// an unpaired surrogate, which a Go string can't carry
+	lone := js.Global().Get("String").Call("fromCharCode", 0x61, 0xD800)
+
+	t.Run("lenient", func(t *testing.T) {
+		conn := dialTest(t, nil, "sink")
+		lastSocket().Call("deliver", lone)
+		if got := string(nextMessage(t, conn)); got != "a�" {
+			t.Fatalf("got %q, want the converted text", got)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		conn := dialTest(t, &Dialer{StrictText: true}, "sink")
+
+		// a real U+FFFD is fine
+		lastSocket().Call("deliver", "b�")
+		if got := string(nextMessage(t, conn)); got != "b�" {
+			t.Fatalf("got %q, want the text unchanged", got)
+		}
+
+		lastSocket().Call("deliver", lone)
+		if _, err := conn.NextMessageContext(testContext(t)); !errors.Is(err, ErrInvalidText) {
+			t.Fatalf("NextMessage returned %v, want ErrInvalidText", err)
+		}
+	})
+}
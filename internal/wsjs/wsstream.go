@@ -0,0 +1,646 @@
+package wsjs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	_ net.Conn      = (*WsStream)(nil)
+	_ io.ReaderFrom = (*WsStream)(nil)
+)
+
+// readFromChunkSize is the most ReadFrom reads from its source per frame
+const readFromChunkSize = 256 * 1024
+
+// WsStream provides a net.Conn interface for WebSocket connections, with
+// messages read and written as a byte stream
+type WsStream struct {
+	// ReadBufferHint, when positive, lets Read coalesce already queued
+	// messages into an internal buffer of about this many bytes, so that
+	// small reads don't cost one NextMessage per message. Set it before the
+	// first Read.
+	ReadBufferHint int
+
+	// RecordSize, when positive, makes Read return only whole records of
+	// this many bytes, coalescing across messages as needed. Read then
+	// fails with io.ErrShortBuffer if p cannot hold a record, and with
+	// io.ErrUnexpectedEOF if the connection ends mid-record. Set it before
+	// the first Read.
+	RecordSize int
+
+	conn          Connection
+	in            messageSource // where reads come from; conn itself if it is one
+	currentBuffer []byte
+	pooled        []byte // pooled buffer backing currentBuffer, if any
+	readMu        sync.Mutex
+	writeMu       sync.Mutex
+
+	// write buffering, see SetWriteBuffer; guarded by writeMu
+	writeBufSize int
+	writeBuf     []byte
+	writeClosed  bool // CloseWrite was called; guarded by writeMu
+
+	readEOF bool // the peer sent FIN; guarded by readMu
+
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	ctx           context.Context // see WithContext
+	// closed and replaced whenever the read deadline or context changes, to
+	// wake a pending Read
+	readDeadlineChanged chan struct{}
+}
+
+// messageSource is the inbound side of a connection as WsStream reads it:
+// a channel it can select on alongside deadlines, refilled after every
+// receive. *Conn is one; any other Connection is pumped into one.
+type messageSource interface {
+	messages() <-chan Message
+	refill()
+	Done() <-chan struct{}
+	closedErr() error
+}
+
+// NewWsStream creates a new WsStream from a WebSocket connection. A *Conn is
+// read directly; any other Connection is read by a goroutine, and the
+// half-close of CloseWrite needs it to also implement SendText and NextFrame.
+func NewWsStream(conn Connection) *WsStream {
+	in, ok := conn.(messageSource)
+	if !ok {
+		in = newPumpSource(conn)
+	}
+	return &WsStream{
+		conn:                conn,
+		in:                  in,
+		readDeadlineChanged: make(chan struct{}),
+	}
+}
+
+// Read implements io.Reader interface
+func (ws *WsStream) Read(p []byte) (n int, err error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	if ws.RecordSize > 0 {
+		return ws.readRecords(p)
+	}
+
+	// If we have remaining data from previous message, use it first
+	if len(ws.currentBuffer) > 0 {
+		n = copy(p, ws.currentBuffer)
+		ws.currentBuffer = ws.currentBuffer[n:]
+		ws.recycleConsumed()
+		return n, nil
+	}
+
+	// Get next message from WebSocket
+	msg, err := ws.nextMessage()
+	if err != nil {
+		return 0, err
+	}
+	data := msg.Data
+
+	// Prefetch whatever else is already queued, up to the hint
+	if ws.ReadBufferHint > 0 && len(data) < ws.ReadBufferHint {
+		buf := make([]byte, len(data), ws.ReadBufferHint)
+		copy(buf, data)
+		recycle(msg)
+		for len(buf) < ws.ReadBufferHint {
+			next, ok := ws.tryNextMessage()
+			if !ok {
+				break
+			}
+			if isFIN(next) {
+				ws.readEOF = true
+				break
+			}
+			buf = append(buf, next.Data...)
+			recycle(next)
+		}
+		data = buf
+	} else if msg.pooled {
+		ws.pooled = data
+	}
+
+	// Copy message data to buffer
+	n = copy(p, data)
+
+	// Store any remaining data for next read
+	if n < len(data) {
+		ws.currentBuffer = data[n:]
+	}
+	ws.recycleConsumed()
+
+	return n, nil
+}
+
+// recycleConsumed returns the pooled buffer to the pool once fully read
+func (ws *WsStream) recycleConsumed() {
+	if ws.pooled != nil && len(ws.currentBuffer) == 0 {
+		putReadBuffer(ws.pooled)
+		ws.pooled = nil
+		ws.currentBuffer = nil
+	}
+}
+
+// recycle returns msg's buffer to the pool once its bytes have been copied
+func recycle(msg Message) {
+	if msg.pooled {
+		putReadBuffer(msg.Data)
+	}
+}
+
+// ReadMessage returns exactly one WebSocket message, for datagram-oriented
+// protocols that need message boundaries. If a previous Read stopped
+// mid-message, the rest of that message is returned first. Mixing Read and
+// ReadMessage on one stream is unsupported otherwise: Read with
+// ReadBufferHint or RecordSize merges messages, whose boundaries are lost.
+// The caller owns the returned slice.
+func (ws *WsStream) ReadMessage() ([]byte, error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	if len(ws.currentBuffer) > 0 {
+		data := ws.currentBuffer
+		ws.currentBuffer = nil
+		// the caller keeps the buffer, so it must not go back to the pool
+		ws.pooled = nil
+		return data, nil
+	}
+
+	msg, err := ws.nextMessage()
+	if err != nil {
+		return nil, err
+	}
+	return msg.Data, nil
+}
+
+// readRecords fills p with as many whole records as fit
+func (ws *WsStream) readRecords(p []byte) (int, error) {
+	size := ws.RecordSize
+	if len(p) < size {
+		return 0, io.ErrShortBuffer
+	}
+
+	for len(ws.currentBuffer) < size {
+		msg, err := ws.nextMessage()
+		if err != nil {
+			// a timeout or cancellation leaves the partial record for the
+			// next Read; only the end of the stream cuts it short
+			if len(ws.currentBuffer) > 0 && isEndOfStream(err) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		ws.currentBuffer = append(ws.currentBuffer, msg.Data...)
+		recycle(msg)
+	}
+
+	n := min(len(p), len(ws.currentBuffer)) / size * size
+	copy(p, ws.currentBuffer[:n])
+	ws.currentBuffer = ws.currentBuffer[n:]
+
+	return n, nil
+}
+
+// isEndOfStream reports whether err from nextMessage means no more data
+// will arrive
+func isEndOfStream(err error) bool {
+	var closeErr *CloseError
+	return err == io.EOF || errors.Is(err, ErrClosed) || errors.As(err, &closeErr)
+}
+
+// tryNextMessage returns an already queued message without blocking. Unlike
+// the exported reads it leaves recycling a pooled message to the caller.
+func (ws *WsStream) tryNextMessage() (Message, bool) {
+	select {
+	case msg := <-ws.in.messages():
+		ws.in.refill()
+		return msg, true
+	default:
+		return Message{}, false
+	}
+}
+
+// nextMessage waits for the next message, honoring the read deadline and
+// context. A deadline that has already passed or a context already done fails
+// without consuming a queued message.
+func (ws *WsStream) nextMessage() (Message, error) {
+	if ws.readEOF {
+		return Message{}, io.EOF
+	}
+
+	for {
+		ws.deadlineMu.Lock()
+		deadline := ws.readDeadline
+		ctx := ws.ctx
+		changed := ws.readDeadlineChanged
+		ws.deadlineMu.Unlock()
+
+		var done <-chan struct{}
+		if ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return Message{}, err
+			}
+			done = ctx.Done()
+		}
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			// checked up front so a passed deadline never races a queued
+			// message in the select below
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return Message{}, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case msg := <-ws.in.messages():
+			ws.in.refill()
+			if timer != nil {
+				timer.Stop()
+			}
+			if isFIN(msg) {
+				ws.readEOF = true
+				return Message{}, io.EOF
+			}
+			return msg, nil
+		case <-ws.in.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return Message{}, ws.in.closedErr()
+		case <-timeout:
+			return Message{}, os.ErrDeadlineExceeded
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return Message{}, ctx.Err()
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// Write implements io.Writer interface
+func (ws *WsStream) Write(p []byte) (n int, err error) {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if err := ws.checkWrite(); err != nil {
+		return 0, err
+	}
+
+	if ws.writeBufSize > 0 && len(ws.writeBuf)+len(p) < ws.writeBufSize {
+		ws.writeBuf = append(ws.writeBuf, p...)
+		return len(p), nil
+	}
+
+	if len(ws.writeBuf) > 0 {
+		// fill the buffer up and send it as one frame
+		ws.writeBuf = append(ws.writeBuf, p...)
+		if err := ws.flushLocked(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	err = ws.conn.Send(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ReadFrom implements io.ReaderFrom, so io.Copy into the stream reads up to
+// readFromChunkSize bytes at a time and sends each read as one frame, paced
+// by any write buffer limits on the Conn. Buffered writes are flushed first.
+// The write lock is only held while sending, so a Close doesn't wait for r.
+// It returns the number of bytes sent. An error from r is returned as is;
+// otherwise the error is the one the send failed with.
+func (ws *WsStream) ReadFrom(r io.Reader) (n int64, err error) {
+	if err := ws.sendChunk(nil); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, readFromChunkSize)
+	for {
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			if err := ws.sendChunk(buf[:nr]); err != nil {
+				return n, err
+			}
+			n += int64(nr)
+		}
+
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// sendChunk flushes buffered writes and sends p, if any, as one frame for
+// ReadFrom
+func (ws *WsStream) sendChunk(p []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if err := ws.checkWrite(); err != nil {
+		return err
+	}
+	if err := ws.flushLocked(); err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+	return ws.conn.Send(p)
+}
+
+// SetWriteBuffer makes Write accumulate data and send it as a single frame
+// once size bytes are pending or on Flush, so many tiny writes don't each
+// cost a frame. Buffered writes merge, so this is for byte-stream use only;
+// WriteMessage sends one frame per call for callers that need message
+// boundaries. Zero, the default, sends every Write right away.
+func (ws *WsStream) SetWriteBuffer(size int) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	ws.writeBufSize = size
+	if size <= 0 {
+		return ws.flushLocked()
+	}
+	return nil
+}
+
+// Flush sends data held back by SetWriteBuffer
+func (ws *WsStream) Flush() error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	return ws.flushLocked()
+}
+
+// WriteMessage sends p as its own frame, after flushing buffered writes
+func (ws *WsStream) WriteMessage(p []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if err := ws.checkWrite(); err != nil {
+		return err
+	}
+	if err := ws.flushLocked(); err != nil {
+		return err
+	}
+	return ws.conn.Send(p)
+}
+
+func (ws *WsStream) flushLocked() error {
+	if len(ws.writeBuf) == 0 {
+		return nil
+	}
+
+	err := ws.conn.Send(ws.writeBuf)
+	ws.writeBuf = ws.writeBuf[:0]
+	return err
+}
+
+// checkWrite fails writes after CloseWrite or past the write deadline
+func (ws *WsStream) checkWrite() error {
+	if ws.writeClosed {
+		return io.ErrClosedPipe
+	}
+
+	ws.deadlineMu.Lock()
+	deadline := ws.writeDeadline
+	ws.deadlineMu.Unlock()
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// CloseWrite half-closes the stream: buffered writes are flushed, the peer is
+// sent a FIN frame, and later writes fail with io.ErrClosedPipe. Reads go on
+// until the peer closes or sends its own FIN. WebSocket has no half-close, so
+// FIN is an application convention: a text frame carrying exactly streamFIN.
+// WsStream only ever writes binary frames, so it can't be mistaken for data.
+// A stream that receives it returns io.EOF from every later read.
+//
+// It fails with errors.ErrUnsupported, leaving the stream writable, if the
+// connection can't send text frames or only accepts binary ones (a Dialer
+// with AllowedFrameTypes = FrameBinary). Such a peer, configured alike, would
+// fail the connection with CloseUnsupportedData on receiving FIN, so the two
+// options don't combine.
+func (ws *WsStream) CloseWrite() error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if ws.writeClosed {
+		return nil
+	}
+	if err := ws.flushLocked(); err != nil {
+		return err
+	}
+	sender, ok := ws.conn.(interface{ SendText(string) error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if c, ok := ws.conn.(interface{ acceptsText() bool }); ok && !c.acceptsText() {
+		return errors.ErrUnsupported
+	}
+	ws.writeClosed = true
+	return sender.SendText(streamFIN)
+}
+
+// streamFIN is the payload of the text frame that signals a half-close
+const streamFIN = "\x00FIN"
+
+func isFIN(msg Message) bool {
+	return msg.Text && string(msg.Data) == streamFIN
+}
+
+// Close flushes buffered writes and closes the WebSocket connection
+func (ws *WsStream) Close() error {
+	ws.Flush()
+	if p, ok := ws.in.(*pumpSource); ok {
+		p.stop()
+	}
+	return ws.conn.Close()
+}
+
+// Done returns a channel that is closed once the underlying connection has
+// closed, by either end
+func (ws *WsStream) Done() <-chan struct{} {
+	return ws.in.Done()
+}
+
+// LocalAddr returns a placeholder address; browsers don't expose the local
+// end of a WebSocket
+func (ws *WsStream) LocalAddr() net.Addr {
+	return wsAddr{host: "local"}
+}
+
+// RemoteAddr returns the address of the URL the connection was dialed with,
+// or a placeholder if the connection doesn't know it
+func (ws *WsStream) RemoteAddr() net.Addr {
+	if c, ok := ws.conn.(interface{ remoteAddr() net.Addr }); ok {
+		return c.remoteAddr()
+	}
+	return wsAddr{host: "remote"}
+}
+
+// SetDeadline sets both the read and write deadlines
+func (ws *WsStream) SetDeadline(t time.Time) error {
+	ws.SetReadDeadline(t)
+	ws.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline makes Read fail with os.ErrDeadlineExceeded, a net.Error
+// whose Timeout reports true, once t has passed. It also applies to a Read
+// already in progress. A zero t disables the deadline.
+func (ws *WsStream) SetReadDeadline(t time.Time) error {
+	ws.deadlineMu.Lock()
+	ws.readDeadline = t
+	close(ws.readDeadlineChanged)
+	ws.readDeadlineChanged = make(chan struct{})
+	ws.deadlineMu.Unlock()
+	return nil
+}
+
+// WithContext makes reads give up with ctx.Err() once ctx is done, so a
+// request handler's cancellation unblocks a Read in progress. It sets the
+// context on ws itself and returns ws. A nil ctx, the default, blocks until a
+// message arrives or the connection closes.
+func (ws *WsStream) WithContext(ctx context.Context) *WsStream {
+	ws.deadlineMu.Lock()
+	ws.ctx = ctx
+	close(ws.readDeadlineChanged)
+	ws.readDeadlineChanged = make(chan struct{})
+	ws.deadlineMu.Unlock()
+	return ws
+}
+
+// SetWriteDeadline makes Write fail with os.ErrDeadlineExceeded once t has
+// passed. A zero t disables the deadline.
+func (ws *WsStream) SetWriteDeadline(t time.Time) error {
+	ws.deadlineMu.Lock()
+	ws.writeDeadline = t
+	ws.deadlineMu.Unlock()
+	return nil
+}
+
+// wsAddr is a net.Addr for a WebSocket endpoint. The query is left out, as it
+// may carry credentials or session tokens that don't belong in logs.
+type wsAddr struct {
+	scheme string
+	host   string
+	path   string
+}
+
+func newWsAddr(raw string) wsAddr {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return wsAddr{host: raw}
+	}
+	return wsAddr{scheme: u.Scheme, host: u.Host, path: u.Path}
+}
+
+func (a wsAddr) Network() string { return "websocket" }
+
+func (a wsAddr) String() string {
+	if a.scheme == "" {
+		return a.host + a.path
+	}
+	return a.scheme + "://" + a.host + a.path
+}
+
+// BufioReadWriter wraps the stream in a bufio.ReadWriter with the given buffer
+// sizes. Every Flush of the writer hands its buffered bytes to a single Write,
+// so small writes are coalesced into one frame per flush (writes larger than
+// wsize may go out directly as their own frame). Callers must Flush after each
+// protocol unit they want delivered; unflushed data is never sent.
+func (ws *WsStream) BufioReadWriter(rsize, wsize int) *bufio.ReadWriter {
+	return bufio.NewReadWriter(bufio.NewReaderSize(ws, rsize), bufio.NewWriterSize(ws, wsize))
+}
+
+// pumpSource reads a Connection that isn't a messageSource from a goroutine,
+// handing over one message at a time. Done is closed only once every message
+// before the failure has been taken.
+type pumpSource struct {
+	msgs      chan Message
+	closeChan chan struct{}
+	err       error // set before closeChan is closed
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+func newPumpSource(conn Connection) *pumpSource {
+	p := &pumpSource{
+		msgs:      make(chan Message),
+		closeChan: make(chan struct{}),
+		stopChan:  make(chan struct{}),
+	}
+	go p.pump(conn)
+	return p
+}
+
+func (p *pumpSource) pump(conn Connection) {
+	framer, _ := conn.(interface {
+		NextFrame() ([]byte, bool, error)
+	})
+
+	for {
+		var msg Message
+		var err error
+		if framer != nil {
+			msg.Data, msg.Text, err = framer.NextFrame()
+		} else {
+			msg.Data, err = conn.NextMessage()
+		}
+		if err != nil {
+			p.err = err
+			close(p.closeChan)
+			return
+		}
+
+		// once stopped, messages are dropped until the closed connection
+		// fails NextMessage
+		select {
+		case p.msgs <- msg:
+		case <-p.stopChan:
+		}
+	}
+}
+
+// stop lets the pump drop messages nobody will read
+func (p *pumpSource) stop() {
+	p.stopOnce.Do(func() { close(p.stopChan) })
+}
+
+func (p *pumpSource) messages() <-chan Message { return p.msgs }
+func (p *pumpSource) refill()                  {}
+func (p *pumpSource) Done() <-chan struct{}    { return p.closeChan }
+func (p *pumpSource) closedErr() error         { return p.err }
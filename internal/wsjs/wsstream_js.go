@@ -1,67 +1,58 @@
 package wsjs
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"net"
 )
 
-// WsStream provides an io.Reader and io.Writer interface for WebSocket connections
-type WsStream struct {
-	conn          *Conn
-	currentBuffer []byte
-	readMu        sync.Mutex
-	writeMu       sync.Mutex
+var _ messageSource = (*Conn)(nil)
+
+// messages is the channel WsStream selects on; see refill
+func (conn *Conn) messages() <-chan Message {
+	return conn.messageChan
 }
 
-// NewWsStream creates a new WsStream from a WebSocket connection
-func NewWsStream(conn *Conn) *WsStream {
-	return &WsStream{
-		conn: conn,
-	}
+// acceptsText reports whether the peer may send text frames, which
+// WsStream.CloseWrite takes to mean it may send them too
+func (conn *Conn) acceptsText() bool {
+	return conn.allowedFrames&FrameText != 0
 }
 
-// Read implements io.Reader interface
-func (ws *WsStream) Read(p []byte) (n int, err error) {
-	ws.readMu.Lock()
-	defer ws.readMu.Unlock()
+func (conn *Conn) remoteAddr() net.Addr {
+	return newWsAddr(conn.url)
+}
 
-	// If we have remaining data from previous message, use it first
-	if len(ws.currentBuffer) > 0 {
-		n = copy(p, ws.currentBuffer)
-		ws.currentBuffer = ws.currentBuffer[n:]
-		return n, nil
+// Stats returns the traffic counters of the underlying connection, or zero
+// counters if it doesn't keep any
+func (ws *WsStream) Stats() ConnStats {
+	if c, ok := ws.conn.(interface{ Stats() ConnStats }); ok {
+		return c.Stats()
 	}
+	return ConnStats{}
+}
 
-	// Get next message from WebSocket
-	msg, err := ws.conn.NextMessage()
-	if err != nil {
-		return 0, err
+// IsAlive reports whether the underlying connection is open, for pools
+// checking a stream before handing it out. It doesn't talk to the peer; see
+// Ping for that.
+func (ws *WsStream) IsAlive() bool {
+	if c, ok := ws.conn.(interface{ State() ReadyState }); ok {
+		return c.State() == StateOpen
 	}
-
-	// Copy message data to buffer
-	n = copy(p, msg)
-
-	// Store any remaining data for next read
-	if n < len(msg) {
-		ws.currentBuffer = msg[n:]
+	select {
+	case <-ws.Done():
+		return false
+	default:
+		return true
 	}
-
-	return n, nil
 }
 
-// Write implements io.Writer interface
-func (ws *WsStream) Write(p []byte) (n int, err error) {
-	ws.writeMu.Lock()
-	defer ws.writeMu.Unlock()
-
-	err = ws.conn.Send(p)
-	if err != nil {
-		return 0, err
+// Ping checks that the peer still answers, see Conn.Ping. It needs a
+// keepalive, e.g. from Dialer.KeepaliveInterval, and fails with
+// errors.ErrUnsupported on a connection that can't ping.
+func (ws *WsStream) Ping(ctx context.Context) error {
+	if c, ok := ws.conn.(interface{ Ping(context.Context) error }); ok {
+		return c.Ping(ctx)
 	}
-
-	return len(p), nil
-}
-
-// Close closes the WebSocket connection
-func (ws *WsStream) Close() error {
-	return ws.conn.Close()
+	return errors.ErrUnsupported
 }
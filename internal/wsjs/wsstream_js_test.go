@@ -0,0 +1,111 @@
+package wsjs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWsStreamSingleByteReads(t *testing.T) {
+	for _, hint := range []int{0, 4, 1024} {
+		t.Run("", func(t *testing.T) {
+			conn := dialTest(t, nil, "echo")
+			ws := NewWsStream(conn)
+			ws.ReadBufferHint = hint
+
+			messages := []string{"abc", "d", "", "efgh", "ij"}
+			want := ""
+			for _, m := range messages {
+				if err := ws.WriteMessage([]byte(m)); err != nil {
+					t.Fatalf("WriteMessage: %v", err)
+				}
+				want += m
+			}
+			// wait for every echo so prefetching sees them queued
+			for conn.Stats().MessagesReceived < uint64(len(messages)) {
+				waitTick()
+			}
+
+			got := make([]byte, 0, len(want))
+			buf := make([]byte, 1)
+			for len(got) < len(want) {
+				n, err := ws.Read(buf)
+				if err != nil {
+					t.Fatalf("Read after %q: %v", got, err)
+				}
+				got = append(got, buf[:n]...)
+			}
+			if string(got) != want {
+				t.Fatalf("read %q with hint %d, want %q", got, hint, want)
+			}
+		})
+	}
+}
+
+func TestWsStreamRecordsSurviveDeadline(t *testing.T) {
+	conn := dialTest(t, nil, "sink")
+	ws := NewWsStream(conn)
+	ws.RecordSize = 4
+
+	lastSocket().Call("deliver", "ab")
+	ws.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	buf := make([]byte, 8)
+	if _, err := ws.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read returned %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// the half record is kept for the next Read
+	ws.SetReadDeadline(time.Time{})
+	lastSocket().Call("deliver", "cd")
+	n, err := ws.Read(buf)
+	if err != nil || string(buf[:n]) != "abcd" {
+		t.Fatalf("Read = %q, %v, want %q", buf[:n], err, "abcd")
+	}
+
+	lastSocket().Call("deliver", "e")
+	done := make(chan error, 1)
+	go func() {
+		_, err := ws.Read(buf)
+		done <- err
+	}()
+	// close once Read holds the partial record
+	time.Sleep(10 * time.Millisecond)
+	lastSocket().Call("_closed", 1000, "", true)
+	if err := <-done; !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Read of a cut record returned %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestWsStreamDone(t *testing.T) {
+	ws := NewWsStream(dialTest(t, nil, "sink"))
+
+	select {
+	case <-ws.Done():
+		t.Fatal("Done closed on an open stream")
+	default:
+	}
+
+	lastSocket().Call("_closed", 1001, "going away", true)
+	select {
+	case <-ws.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the peer closed")
+	}
+}
+
+func TestWsStreamCloseWriteBinaryOnly(t *testing.T) {
+	ws := NewWsStream(dialTest(t, &Dialer{AllowedFrameTypes: FrameBinary}, "sink"))
+
+	if err := ws.CloseWrite(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("CloseWrite returned %v, want errors.ErrUnsupported", err)
+	}
+	if _, err := ws.Write([]byte("data")); err != nil {
+		t.Fatalf("Write after a refused CloseWrite: %v", err)
+	}
+	if got := sentFrames(); !slices.Equal(got, []string{"data"}) {
+		t.Fatalf("sent %q, want only the data and no FIN", got)
+	}
+}
@@ -0,0 +1,185 @@
+//go:build !js
+
+package wsjs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestStream(t *testing.T) (*WsStream, *memConn) {
+	t.Helper()
+
+	local, peer := newMemConnPair(64)
+	ws := NewWsStream(local)
+	t.Cleanup(func() {
+		ws.Close()
+		peer.Close()
+	})
+	return ws, peer
+}
+
+func TestMemStreamReadWrite(t *testing.T) {
+	ws, peer := newTestStream(t)
+
+	if _, err := ws.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if data, err := peer.NextMessage(); err != nil || string(data) != "hello" {
+		t.Fatalf("peer got %q, %v, want hello", data, err)
+	}
+
+	peer.Send([]byte("world"))
+	buf := make([]byte, 3)
+	var got []byte
+	for len(got) < len("world") {
+		n, err := ws.Read(buf)
+		if err != nil {
+			t.Fatalf("Read after %q: %v", got, err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "world" {
+		t.Fatalf("read %q, want world", got)
+	}
+}
+
+func TestMemStreamReadBufferHint(t *testing.T) {
+	ws, peer := newTestStream(t)
+	ws.ReadBufferHint = 1024
+
+	for _, m := range []string{"ab", "cd", "ef"} {
+		peer.Send([]byte(m))
+	}
+	// the pump hands over one message at a time, so a Read may coalesce
+	// only some of them
+	var got []byte
+	buf := make([]byte, 16)
+	for len(got) < 6 {
+		n, err := ws.Read(buf)
+		if err != nil {
+			t.Fatalf("Read after %q: %v", got, err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "abcdef" {
+		t.Fatalf("read %q, want abcdef", got)
+	}
+}
+
+func TestMemStreamCloseWrite(t *testing.T) {
+	ws, peer := newTestStream(t)
+
+	ws.SetWriteBuffer(64)
+	ws.Write([]byte("last"))
+	if err := ws.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	if data, err := peer.NextMessage(); err != nil || string(data) != "last" {
+		t.Fatalf("peer got %q, %v, want the flushed write", data, err)
+	}
+	if data, text, err := peer.NextFrame(); err != nil || !text || string(data) != streamFIN {
+		t.Fatalf("peer got %q (text %v), %v, want the FIN frame", data, text, err)
+	}
+	if _, err := ws.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Write after CloseWrite returned %v, want io.ErrClosedPipe", err)
+	}
+
+	// reads go on until the peer's own FIN
+	peer.Send([]byte("reply"))
+	peer.SendText(streamFIN)
+	data, err := ws.ReadMessage()
+	if err != nil || string(data) != "reply" {
+		t.Fatalf("ReadMessage returned %q, %v, want reply", data, err)
+	}
+	for range 2 {
+		if _, err := ws.Read(make([]byte, 8)); err != io.EOF {
+			t.Fatalf("Read after FIN returned %v, want io.EOF", err)
+		}
+	}
+}
+
+func TestMemStreamCloseWriteUnsupported(t *testing.T) {
+	local, peer := newMemConnPair(4)
+	defer peer.Close()
+
+	// hide SendText and NextFrame behind a bare Connection
+	ws := NewWsStream(struct{ Connection }{local})
+	defer ws.Close()
+
+	if err := ws.CloseWrite(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("CloseWrite returned %v, want errors.ErrUnsupported", err)
+	}
+	// the stream stays writable
+	if _, err := ws.Write([]byte("x")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func TestMemStreamReadDeadline(t *testing.T) {
+	ws, peer := newTestStream(t)
+
+	ws.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := ws.Read(make([]byte, 8)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read returned %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// the stream is still usable once the deadline is lifted
+	ws.SetReadDeadline(time.Time{})
+	peer.Send([]byte("late"))
+	if data, err := ws.ReadMessage(); err != nil || string(data) != "late" {
+		t.Fatalf("ReadMessage returned %q, %v, want late", data, err)
+	}
+}
+
+func TestMemStreamPeerClose(t *testing.T) {
+	ws, peer := newTestStream(t)
+
+	peer.Send([]byte("queued"))
+	peer.Close()
+
+	// a message sent before the close is still read
+	if data, err := ws.ReadMessage(); err != nil || string(data) != "queued" {
+		t.Fatalf("ReadMessage returned %q, %v, want queued", data, err)
+	}
+	if _, err := ws.Read(make([]byte, 8)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Read after peer close returned %v, want ErrClosed", err)
+	}
+	select {
+	case <-ws.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the peer closed")
+	}
+	if _, err := ws.Write([]byte("x")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Write after peer close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestMemStreamRemoteAddr(t *testing.T) {
+	ws, _ := newTestStream(t)
+
+	if got := ws.RemoteAddr().String(); got != "remote" {
+		t.Fatalf("RemoteAddr = %q, want the placeholder", got)
+	}
+}
+
+func TestBridgeWsStreamPeerClose(t *testing.T) {
+	ws, peer := newTestStream(t)
+	other := newFakeStream()
+
+	done := make(chan error, 1)
+	go func() { done <- Bridge(ws, other) }()
+
+	peer.Send([]byte("bye"))
+	peer.Close()
+
+	if err := waitBridge(t, done); err != nil {
+		t.Fatalf("Bridge returned %v, want nil when the peer closes", err)
+	}
+	if got := other.output(); got != "bye" {
+		t.Fatalf("relayed %q, want bye", got)
+	}
+}